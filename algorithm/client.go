@@ -9,10 +9,11 @@ import (
 	"github.com/air-iot/errors"
 	"github.com/air-iot/json"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/air-iot/api-client-go/v4/algorithm"
 	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
 )
 
 type Client struct {
@@ -69,10 +70,14 @@ func (c *Client) restart() {
 
 func (c *Client) connAlgorithm() error {
 	logger.Infof("连接算法管理: 配置=%+v", Cfg.AlgorithmGrpc)
+	opts, err := grpcx.DialOptions(Cfg.AlgorithmGrpc.TLS, Cfg.AlgorithmGrpc.Token)
+	if err != nil {
+		return fmt.Errorf("grpc证书配置错误: %w", err)
+	}
 	conn, err := grpc.DialContext(
 		context.Background(),
 		fmt.Sprintf("%s:%d", Cfg.AlgorithmGrpc.Host, Cfg.AlgorithmGrpc.Port),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+		opts...)
 	if err != nil {
 		return fmt.Errorf("grpc.Dial error: %s", err)
 	}
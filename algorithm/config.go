@@ -5,6 +5,9 @@ import (
 	"encoding/hex"
 	"github.com/air-iot/logger"
 	"google.golang.org/grpc/metadata"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
 )
 
 var Cfg = new(Config)
@@ -34,7 +37,9 @@ type GrpcConfig struct {
 		RequestTime int `json:"requestTime" yaml:"requestTime"`
 		Retry       int `json:"retry" yaml:"retry"`
 	} `json:"health" yaml:"health"`
-	WaitTime int `json:"waitTime" yaml:"waitTime"`
+	WaitTime int         `json:"waitTime" yaml:"waitTime"`
+	TLS      tlsx.Config `json:"tls" yaml:"tls"`
+	Token    grpcx.Token `json:"token" yaml:"token"` // 认证token,每次请求以Bearer token携带
 }
 
 func GetGrpcContext(ctx context.Context, serviceId, id, name string) context.Context {
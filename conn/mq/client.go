@@ -10,18 +10,38 @@ const (
 	Mqtt   string = "MQTT"
 	Rabbit string = "RABBIT"
 	Kafka  string = "KAFKA"
+	Memory string = "MEMORY"
+	Nats   string = "NATS"
+	Redis  string = "REDIS"
 )
 
 type Config struct {
-	Type    string         `json:"type" yaml:"type"`
-	Timeout time.Duration  `json:"timeout" yaml:"timeout"`
-	MQTT    MQTTConfig     `json:"mqtt" yaml:"mqtt"`
-	Rabbit  RabbitMQConfig `json:"rabbit" yaml:"rabbit"`
-	Kafka   KafkaConfig    `json:"kafka" yaml:"kafka"`
+	Type        string            `json:"type" yaml:"type"`
+	Timeout     time.Duration     `json:"timeout" yaml:"timeout"`
+	Codec       string            `json:"codec" yaml:"codec"` // 数据点编码: json(默认)、msgpack、cbor、protobuf,非json编码需要消息队列支持元数据
+	Compression CompressionConfig `json:"compression" yaml:"compression"`
+	MQTT        MQTTConfig        `json:"mqtt" yaml:"mqtt"`
+	Rabbit      RabbitMQConfig    `json:"rabbit" yaml:"rabbit"`
+	Kafka       KafkaConfig       `json:"kafka" yaml:"kafka"`
+	Memory      MemoryConfig      `json:"memory" yaml:"memory"`
+	NATS        NATSConfig        `json:"nats" yaml:"nats"`
+	Redis       RedisConfig       `json:"redis" yaml:"redis"`
 }
 
 // NewMQ 创建消息队列
 func NewMQ(cfg Config) (MQ, func(), error) {
+	m, clean, err := newMQ(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	if m, err = withCompression(m, cfg.Compression); err != nil {
+		clean()
+		return nil, nil, err
+	}
+	return m, clean, nil
+}
+
+func newMQ(cfg Config) (MQ, func(), error) {
 	switch strings.ToUpper(cfg.Type) {
 	case Rabbit:
 		return NewRabbitClient(cfg.Rabbit)
@@ -29,6 +49,12 @@ func NewMQ(cfg Config) (MQ, func(), error) {
 		return NewMQTTClient(cfg.MQTT)
 	case Kafka:
 		return NewKafkaClient(cfg.Kafka)
+	case Memory:
+		return NewMemoryClient(cfg.Memory)
+	case Nats:
+		return NewNATSClient(cfg.NATS)
+	case Redis:
+		return NewRedisClient(cfg.Redis)
 	default:
 		return nil, nil, fmt.Errorf("未知mq类型")
 	}
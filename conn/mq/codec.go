@@ -0,0 +1,153 @@
+package mq
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/air-iot/json"
+	"github.com/ugorji/go/codec"
+	"google.golang.org/protobuf/proto"
+)
+
+// 消息编码名称
+const (
+	CodecJSON     = "json"
+	CodecMsgpack  = "msgpack"
+	CodecCBOR     = "cbor"
+	CodecProtobuf = "protobuf"
+)
+
+// Codec 消息内容编码,ContentType通过消息元数据content-type发送给订阅方
+type Codec interface {
+	Name() string
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ProtoMarshaler 可编码为protobuf的类型,不是proto.Message时protobuf编码使用该接口
+type ProtoMarshaler interface {
+	MarshalProto() ([]byte, error)
+}
+
+// ProtoUnmarshaler 可从protobuf解码的类型,不是proto.Message时protobuf解码使用该接口
+type ProtoUnmarshaler interface {
+	UnmarshalProto(data []byte) error
+}
+
+var codecs = map[string]Codec{
+	CodecJSON:     jsonCodec{},
+	CodecMsgpack:  newUgorjiCodec(CodecMsgpack, "application/msgpack", msgpackHandle()),
+	CodecCBOR:     newUgorjiCodec(CodecCBOR, "application/cbor", cborHandle()),
+	CodecProtobuf: protobufCodec{},
+}
+
+// NewCodec 按名称查询消息编码,名称为空时使用json
+func NewCodec(name string) (Codec, error) {
+	if name == "" {
+		name = CodecJSON
+	}
+	c, ok := codecs[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("未知消息编码: %s", name)
+	}
+	return c, nil
+}
+
+// CodecByContentType 按消息元数据content-type查询消息编码,为空时使用json
+func CodecByContentType(contentType string) (Codec, error) {
+	if contentType == "" {
+		return codecs[CodecJSON], nil
+	}
+	for _, c := range codecs {
+		if c.ContentType() == contentType {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("未知消息内容类型: %s", contentType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string        { return CodecJSON }
+func (jsonCodec) ContentType() string { return "application/json" }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func msgpackHandle() codec.Handle {
+	h := new(codec.MsgpackHandle)
+	h.WriteExt = true
+	h.RawToString = true
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+func cborHandle() codec.Handle {
+	h := new(codec.CborHandle)
+	h.MapType = reflect.TypeOf(map[string]interface{}(nil))
+	return h
+}
+
+// ugorjiCodec msgpack和cbor编码,结构体字段名使用json标签
+type ugorjiCodec struct {
+	name        string
+	contentType string
+	handle      codec.Handle
+}
+
+func newUgorjiCodec(name, contentType string, handle codec.Handle) *ugorjiCodec {
+	return &ugorjiCodec{name: name, contentType: contentType, handle: handle}
+}
+
+func (c *ugorjiCodec) Name() string        { return c.name }
+func (c *ugorjiCodec) ContentType() string { return c.contentType }
+
+func (c *ugorjiCodec) Marshal(v interface{}) ([]byte, error) {
+	var b []byte
+	if err := codec.NewEncoderBytes(&b, c.handle).Encode(v); err != nil {
+		return nil, fmt.Errorf("%s编码错误: %w", c.name, err)
+	}
+	return b, nil
+}
+
+func (c *ugorjiCodec) Unmarshal(data []byte, v interface{}) error {
+	if err := codec.NewDecoderBytes(data, c.handle).Decode(v); err != nil {
+		return fmt.Errorf("%s解码错误: %w", c.name, err)
+	}
+	return nil
+}
+
+// protobufCodec protobuf编码,支持proto.Message以及实现ProtoMarshaler、ProtoUnmarshaler的类型
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string        { return CodecProtobuf }
+func (protobufCodec) ContentType() string { return "application/x-protobuf" }
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Marshal(m)
+	case ProtoMarshaler:
+		return m.MarshalProto()
+	default:
+		return nil, fmt.Errorf("类型 %T 不支持protobuf编码", v)
+	}
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	switch m := v.(type) {
+	case proto.Message:
+		return proto.Unmarshal(data, m)
+	case ProtoUnmarshaler:
+		return m.UnmarshalProto(data)
+	default:
+		return fmt.Errorf("类型 %T 不支持protobuf解码", v)
+	}
+}
@@ -0,0 +1,71 @@
+package mq
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type codecPoint struct {
+	ID     string                 `json:"id"`
+	Fields map[string]interface{} `json:"fields"`
+	Time   int64                  `json:"time"`
+}
+
+func TestCodec(t *testing.T) {
+	for _, name := range []string{"", CodecJSON, CodecMsgpack, "CBOR"} {
+		c, err := NewCodec(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := codecPoint{ID: "d1", Fields: map[string]interface{}{"p1": 1.5, "p2": "on"}, Time: 1700000000000}
+		b, err := c.Marshal(&in)
+		if err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		var out codecPoint
+		if err := c.Unmarshal(b, &out); err != nil {
+			t.Fatalf("%s: %v", c.Name(), err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("%s: 解码结果应为=%+v,实际为=%+v", c.Name(), in, out)
+		}
+		byType, err := CodecByContentType(c.ContentType())
+		if err != nil || byType.Name() != c.Name() {
+			t.Errorf("%s: 按内容类型查询编码错误: %v", c.Name(), err)
+		}
+	}
+	// msgpack按json标签编码字段名
+	c, _ := NewCodec(CodecMsgpack)
+	var raw map[string]interface{}
+	b, _ := c.Marshal(&codecPoint{ID: "d1"})
+	if err := c.Unmarshal(b, &raw); err != nil || raw["id"] != "d1" {
+		t.Errorf("msgpack字段名应使用json标签: %v %v", raw, err)
+	}
+	if _, err := NewCodec("xml"); err == nil {
+		t.Error("未知编码应返回错误")
+	}
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c, err := NewCodec(CodecProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in, _ := structpb.NewStruct(map[string]interface{}{"p1": 1.5})
+	b, err := c.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := new(structpb.Struct)
+	if err := c.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Fields["p1"].GetNumberValue() != 1.5 {
+		t.Errorf("解码结果错误: %v", out)
+	}
+	if _, err := c.Marshal(&codecPoint{}); err == nil {
+		t.Error("不支持protobuf的类型应返回错误")
+	}
+}
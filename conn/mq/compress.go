@@ -0,0 +1,174 @@
+package mq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+
+	"github.com/air-iot/logger"
+)
+
+// HeaderContentEncoding 消息内容的压缩方式,订阅时按该元数据自动解压
+const HeaderContentEncoding = "content-encoding"
+
+// 压缩方式
+const (
+	CompressionGzip   = "gzip"
+	CompressionZstd   = "zstd"
+	CompressionSnappy = "snappy"
+)
+
+// CompressionConfig 消息压缩配置,只压缩不小于Threshold的消息,压缩方式通过消息元数据content-encoding发送.
+// 消息队列不支持元数据时不压缩,见HeadersSupported
+type CompressionConfig struct {
+	Type      string `json:"type" yaml:"type"`           // 压缩方式: gzip、zstd、snappy,为空时不压缩
+	Threshold int    `json:"threshold" yaml:"threshold"` // 压缩阈值(字节),默认1024
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+func initZstd() error {
+	zstdOnce.Do(func() {
+		if zstdEncoder, zstdErr = zstd.NewWriter(nil); zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+// compress 按压缩方式压缩消息内容
+func compress(encoding string, payload []byte) ([]byte, error) {
+	switch encoding {
+	case CompressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdEncoder.EncodeAll(payload, nil), nil
+	case CompressionSnappy:
+		return snappy.Encode(nil, payload), nil
+	default:
+		return nil, fmt.Errorf("未知压缩方式: %s", encoding)
+	}
+}
+
+// decompress 按消息元数据content-encoding解压消息内容,encoding为空时返回原内容
+func decompress(encoding string, payload []byte) ([]byte, error) {
+	switch encoding {
+	case "":
+		return payload, nil
+	case CompressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	case CompressionZstd:
+		if err := initZstd(); err != nil {
+			return nil, err
+		}
+		return zstdDecoder.DecodeAll(payload, nil)
+	case CompressionSnappy:
+		return snappy.Decode(nil, payload)
+	default:
+		return nil, fmt.Errorf("未知压缩方式: %s", encoding)
+	}
+}
+
+// decompressPayload 订阅收到消息时解压,解压失败时记录日志并返回false,不再调用Handler
+func decompressPayload(topic, encoding string, payload []byte) ([]byte, bool) {
+	b, err := decompress(encoding, payload)
+	if err != nil {
+		logger.Errorf("消息解压错误: 主题=%s,压缩方式=%s. %s", topic, encoding, err.Error())
+		return nil, false
+	}
+	return b, true
+}
+
+// compressMQ 发送消息时压缩超过阈值的消息内容
+type compressMQ struct {
+	MQ
+	cfg CompressionConfig
+}
+
+// withCompression 按配置包装消息队列,未配置压缩或消息队列不支持元数据时返回原消息队列
+func withCompression(m MQ, cfg CompressionConfig) (MQ, error) {
+	if cfg.Type == "" {
+		return m, nil
+	}
+	cfg.Type = strings.ToLower(cfg.Type)
+	if _, err := compress(cfg.Type, nil); err != nil {
+		return nil, err
+	}
+	if !HeadersSupported(m) {
+		logger.Warnf("消息队列 %T 不支持消息元数据,不压缩消息", m)
+		return m, nil
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 1024
+	}
+	return &compressMQ{MQ: m, cfg: cfg}, nil
+}
+
+func (c *compressMQ) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return c.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+func (c *compressMQ) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
+	if len(payload) >= c.cfg.Threshold {
+		b, err := compress(c.cfg.Type, payload)
+		if err != nil {
+			return fmt.Errorf("消息压缩错误: %w", err)
+		}
+		h := make(Header, len(header)+1)
+		for k, v := range header {
+			h[k] = v
+		}
+		h[HeaderContentEncoding] = c.cfg.Type
+		payload, header = b, h
+	}
+	return PublishWithHeader(ctx, c.MQ, topicParams, payload, header)
+}
+
+// ConsumeWithRetry 消息队列自行实现重试订阅时转交,否则死信消息同样按配置压缩
+func (c *compressMQ) ConsumeWithRetry(ctx context.Context, topicParams []string, splitN int, cfg RetryConfig, handler ErrorHandler) error {
+	if r, ok := c.MQ.(retryConsumer); ok {
+		return r.ConsumeWithRetry(ctx, topicParams, splitN, cfg, handler)
+	}
+	return c.MQ.Consume(ctx, topicParams, splitN, newRetryHandler(c, cfg, handler))
+}
+
+func (c *compressMQ) HeadersSupported() bool {
+	return HeadersSupported(c.MQ)
+}
+
+func (c *compressMQ) Subscriptions() []Subscription {
+	return Subscriptions(c.MQ)
+}
+
+func (c *compressMQ) LostErr() error {
+	return LostErr(c.MQ)
+}
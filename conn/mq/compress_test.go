@@ -0,0 +1,92 @@
+package mq
+
+import (
+	"bytes"
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestCompress(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"id":"d1","fields":{"p1":1.5}}`), 100)
+	for _, typ := range []string{CompressionGzip, CompressionZstd, CompressionSnappy} {
+		b, err := compress(typ, payload)
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if len(b) >= len(payload) {
+			t.Errorf("%s: 压缩后大小=%d,应小于=%d", typ, len(b), len(payload))
+		}
+		out, err := decompress(typ, b)
+		if err != nil {
+			t.Fatalf("%s: %v", typ, err)
+		}
+		if !bytes.Equal(out, payload) {
+			t.Errorf("%s: 解压结果错误", typ)
+		}
+	}
+	if _, err := decompress("br", payload); err == nil {
+		t.Error("未知压缩方式应返回错误")
+	}
+	memory, _, _ := NewMemoryClient(MemoryConfig{})
+	if m, err := withCompression(memory, CompressionConfig{Type: CompressionGzip}); err != nil || m != memory {
+		t.Errorf("不支持元数据的消息队列不应压缩: %T %v", m, err)
+	}
+	if _, err := withCompression(memory, CompressionConfig{Type: "br"}); err == nil {
+		t.Error("未知压缩方式应返回错误")
+	}
+}
+
+func TestCompressMQ(t *testing.T) {
+	s := miniredis.RunT(t)
+	port, _ := strconv.Atoi(s.Port())
+	cli, clean, err := NewMQ(Config{
+		Type:        Redis,
+		Compression: CompressionConfig{Type: "ZSTD", Threshold: 64},
+		Redis:       RedisConfig{Host: s.Host(), Port: port, Block: time.Millisecond * 50},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	received := make(chan []byte, 10)
+	if err := cli.Consume(ctx, []string{"data", "t1"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		received <- payload
+	}); err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("a"), 1000)
+	for _, payload := range [][]byte{[]byte("small"), large} {
+		if err := PublishWithHeader(ctx, cli, []string{"data", "t1"}, payload, Header{HeaderContentType: "application/json"}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case got := <-received:
+			if !bytes.Equal(got, payload) {
+				t.Errorf("收到的消息应为解压后的内容,大小=%d,实际大小=%d", len(payload), len(got))
+			}
+		case <-ctx.Done():
+			t.Fatal("未收到订阅的消息")
+		}
+	}
+	// 只有超过阈值的消息压缩
+	msgs, err := cli.(*compressMQ).MQ.(*redisMQ).client.XRange(ctx, "airiot:mq:data/t1", "-", "+").Result()
+	if err != nil || len(msgs) != 2 {
+		t.Fatalf("查询消息错误: %v %v", msgs, err)
+	}
+	if _, ok := msgs[0].Values[redisHeaderPrefix+HeaderContentEncoding]; ok {
+		t.Error("小于阈值的消息不应压缩")
+	}
+	if msgs[1].Values[redisHeaderPrefix+HeaderContentEncoding] != CompressionZstd {
+		t.Errorf("压缩方式应为=zstd,实际为=%v", msgs[1].Values)
+	}
+	if msgs[1].Values[redisHeaderPrefix+HeaderContentType] != "application/json" {
+		t.Errorf("应保留原有元数据: %v", msgs[1].Values)
+	}
+}
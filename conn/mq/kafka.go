@@ -2,26 +2,33 @@ package mq
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/IBM/sarama"
 	"github.com/air-iot/logger"
+	"github.com/xdg-go/scram"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
 )
 
 var _ MQ = new(kafka)
 
 type kafka struct {
-	lock                 sync.RWMutex
-	config               KafkaConfig
-	client               sarama.Client
-	producer             sarama.SyncProducer
-	consumer             sarama.ConsumerGroup
-	callbacks            []Callback
-	consumerTopicContext map[string]context.CancelFunc
+	lostError
+	subscriptionRegistry[*kafkaSubscription]
+	lock          sync.RWMutex
+	config        KafkaConfig
+	client        sarama.Client
+	producer      sarama.SyncProducer
+	asyncProducer sarama.AsyncProducer
+	callbacks     []Callback
+	groups        map[string]*kafkaGroup
+	disconnected  atomic.Bool
 }
 
 // KafkaConfig mqtt配置参数
@@ -34,6 +41,43 @@ type KafkaConfig struct {
 	Balancer        string
 	Partition       *int32
 	AutoCommit      *bool
+	SASL            KafkaSASLConfig
+	TLS             tlsx.Config
+	Producer        KafkaProducerConfig
+}
+
+// KafkaSASLConfig kafka SASL认证配置
+type KafkaSASLConfig struct {
+	Enable    bool
+	Mechanism string // PLAIN、SCRAM-SHA-256或SCRAM-SHA-512,默认PLAIN
+	Username  string
+	Password  string
+}
+
+// KafkaProducerConfig kafka生产者配置
+type KafkaProducerConfig struct {
+	// Async 异步发送,发送消息只写入缓冲,由后台批量发送,发送错误只记录日志
+	Async          bool
+	FlushFrequency time.Duration // 批量发送的时间间隔
+	FlushMessages  int           // 批量发送的消息数量
+	FlushBytes     int           // 批量发送的消息大小
+	Compression    string        // 压缩方式 none、gzip、snappy、lz4、zstd
+}
+
+// kafkaSubscription 订阅信息
+type kafkaSubscription struct {
+	splitN  int
+	handler Handler
+}
+
+// kafkaGroup kafka主题的消费者,同一主题的多个订阅共用一个消费者,收到的消息分发给所有匹配的订阅.
+// 主题的最后一个订阅取消时停止消费者
+type kafkaGroup struct {
+	topic    string
+	consumer sarama.ConsumerGroup
+	cancel   context.CancelFunc
+	done     chan struct{}
+	subs     subscriptionRegistry[*kafkaSubscription]
 }
 
 // NewKafkaClient 创建Kafka消息队列
@@ -41,22 +85,42 @@ func NewKafkaClient(cfg KafkaConfig) (mq MQ, clean func(), err error) {
 	cli := new(kafka)
 	cli.config = cfg
 	cli.callbacks = make([]Callback, 0)
-	cli.consumerTopicContext = make(map[string]context.CancelFunc)
+	cli.groups = make(map[string]*kafkaGroup)
 	client, err := cli.getClient()
 	if err != nil {
 		return nil, nil, err
 	}
+	cli.client = client
 	cleanFunc := func() {
 		logger.Infof("关闭kafka客户端")
-		if err := client.Close(); err != nil {
+		for _, sub := range cli.Subscriptions() {
+			cli.unsubscribe(sub.Topic)
+		}
+		cli.lock.Lock()
+		if cli.asyncProducer != nil {
+			if err := cli.asyncProducer.Close(); err != nil {
+				logger.Errorf("关闭kafka生产者错误:%v", err)
+			}
+		}
+		if cli.producer != nil {
+			if err := cli.producer.Close(); err != nil {
+				logger.Errorf("关闭kafka生产者错误:%v", err)
+			}
+		}
+		cli.lock.Unlock()
+		if err := client.Close(); err != nil && !errors.Is(err, sarama.ErrClosedClient) {
 			logger.Errorf("关闭kafka客户端错误:%v", err)
 		}
 	}
-	cli.client = client
 	return cli, cleanFunc, nil
 }
 
-func (k *kafka) Publish(_ context.Context, topicParams []string, payload []byte) error {
+func (k *kafka) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return k.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+// PublishWithHeader 发送消息,元数据使用消息头,kafka版本低于0.11时不发送元数据
+func (k *kafka) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
 	if len(topicParams) == 0 {
 		return fmt.Errorf("topic为空")
 	}
@@ -73,6 +137,23 @@ func (k *kafka) Publish(_ context.Context, topicParams []string, payload []byte)
 	if k.config.Partition != nil {
 		msg.Partition = *k.config.Partition
 	}
+	if k.HeadersSupported() {
+		for key, v := range header {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(key), Value: []byte(v)})
+		}
+	}
+	if k.config.Producer.Async {
+		producer, err := k.getAsyncProducer()
+		if err != nil {
+			return err
+		}
+		select {
+		case producer.Input() <- msg:
+			return nil
+		case <-ctx.Done():
+			return fmt.Errorf("发送消息错误:%w", ctx.Err())
+		}
+	}
 	producer, err := k.getProducer()
 	if err != nil {
 		return err
@@ -88,32 +169,78 @@ func (k *kafka) Consume(ctx context.Context, topicParams []string, splitN int, h
 	if len(topicParams) == 0 {
 		return fmt.Errorf("topic为空")
 	}
-	errChan := make(chan error)
-	newCtx, newCancel := context.WithCancel(ctx)
-	kh := &kafkaHandler{topicParams: topicParams, topicString: strings.Join(topicParams, TOPICSEPWITHMQTT), splitN: splitN, handler: handler, k: k, errChan: errChan, cancel: newCancel}
-	go func() {
-		select {
-		case <-newCtx.Done():
-			logger.Infof("订阅数据,发起停止,topic:%+v", topicParams)
-		case err := <-errChan:
-			if err != nil {
-				logger.Errorf("订阅数据错误,收到管道信息,topic:%+v,错误:%v", topicParams, err)
-			}
+	topicString := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	k.lock.Lock()
+	defer k.lock.Unlock()
+	if _, ok := k.get(topicParams); ok {
+		return fmt.Errorf("topic %s 已订阅", topicString)
+	}
+	sub := &kafkaSubscription{splitN: splitN, handler: handler}
+	group, ok := k.groups[topicParams[0]]
+	if !ok {
+		consumer, err := sarama.NewConsumerGroupFromClient(k.config.GroupID, k.client)
+		if err != nil {
+			return fmt.Errorf("创建消费者错误:%w", err)
 		}
-		//close(errChan)
-		k.clean()
-	}()
-	return kh.consume(newCtx)
+		newCtx, newCancel := context.WithCancel(context.Background())
+		group = &kafkaGroup{topic: topicParams[0], consumer: consumer, cancel: newCancel, done: make(chan struct{})}
+		k.groups[group.topic] = group
+		go (&kafkaHandler{group: group, k: k}).consume(newCtx)
+	}
+	group.subs.add(topicParams, splitN, sub)
+	k.add(topicParams, splitN, sub)
+	return nil
+}
+
+// ConsumeWithRetry 重试在消费者的处理协程中进行,重试期间不拉取消息也不能响应重新平衡,
+// 因此重试总时长限制为会话超时和重新平衡超时中较小值的一半,防止消费者被移出消费者组
+func (k *kafka) ConsumeWithRetry(ctx context.Context, topicParams []string, splitN int, cfg RetryConfig, handler ErrorHandler) error {
+	group := k.client.Config().Consumer.Group
+	limit := group.Session.Timeout
+	if group.Rebalance.Timeout < limit {
+		limit = group.Rebalance.Timeout
+	}
+	if limit /= 2; cfg.MaxElapsed <= 0 || cfg.MaxElapsed > limit {
+		cfg.MaxElapsed = limit
+	}
+	return k.Consume(ctx, topicParams, splitN, newRetryHandler(k, cfg, handler))
 }
 
 func (k *kafka) UnSubscription(_ context.Context, topicParams []string) error {
 	if len(topicParams) == 0 {
 		return fmt.Errorf("topic为空")
 	}
-	//k.cancelFunc(topicParams[0])
+	k.unsubscribe(topicParams)
 	return nil
 }
 
+// unsubscribe 取消订阅,主题没有其他订阅时停止消费者并等待退出
+func (k *kafka) unsubscribe(topicParams []string) {
+	k.lock.Lock()
+	if _, ok := k.remove(topicParams); !ok {
+		k.lock.Unlock()
+		return
+	}
+	group := k.groups[topicParams[0]]
+	group.subs.remove(topicParams)
+	if len(group.subs.list()) > 0 {
+		k.lock.Unlock()
+		return
+	}
+	delete(k.groups, group.topic)
+	k.lock.Unlock()
+	group.cancel()
+	if err := group.consumer.Close(); err != nil {
+		logger.Errorf("关闭消费者错误,topic:%s,错误:%v", group.topic, err)
+	}
+	<-group.done
+}
+
+// HeadersSupported kafka版本不低于0.11时支持消息头
+func (k *kafka) HeadersSupported() bool {
+	return k.client.Config().Version.IsAtLeast(sarama.V0_11_0_0)
+}
+
 func (k *kafka) Callback(cb Callback) {
 	k.lock.Lock()
 	defer k.lock.Unlock()
@@ -135,7 +262,8 @@ func (k *kafka) getConfig() (c *sarama.Config, err error) {
 	config := sarama.NewConfig()
 	config.Version = version
 	config.Consumer.Return.Errors = true
-	config.Producer.Return.Successes = true
+	config.Producer.Return.Successes = !k.config.Producer.Async
+	config.Producer.Return.Errors = true
 	//config.Consumer.Group.Rebalance.Timeout = 120 * time.Second
 	if k.config.MaxOpenRequests != 0 {
 		config.Net.MaxOpenRequests = k.config.MaxOpenRequests
@@ -156,9 +284,76 @@ func (k *kafka) getConfig() (c *sarama.Config, err error) {
 	if k.config.ClientID != "" {
 		config.ClientID = k.config.ClientID
 	}
+	if err := k.setSecurity(config); err != nil {
+		return nil, err
+	}
+	if err := k.setProducer(config); err != nil {
+		return nil, err
+	}
 	return config, nil
 }
 
+// setSecurity 设置SASL认证和TLS
+func (k *kafka) setSecurity(config *sarama.Config) error {
+	tlsCfg, err := k.config.TLS.Load()
+	if err != nil {
+		return err
+	}
+	if tlsCfg != nil {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsCfg
+	}
+	sasl := k.config.SASL
+	if !sasl.Enable {
+		return nil
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.User = sasl.Username
+	config.Net.SASL.Password = sasl.Password
+	switch strings.ToUpper(sasl.Mechanism) {
+	case "", sarama.SASLTypePlaintext:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA256} }
+	case sarama.SASLTypeSCRAMSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return &scramClient{hash: scram.SHA512} }
+	default:
+		return fmt.Errorf("不支持的kafka SASL认证方式:%s", sasl.Mechanism)
+	}
+	return nil
+}
+
+// setProducer 设置批量发送和压缩
+func (k *kafka) setProducer(config *sarama.Config) error {
+	p := k.config.Producer
+	if p.FlushFrequency > 0 {
+		config.Producer.Flush.Frequency = p.FlushFrequency
+	}
+	if p.FlushMessages > 0 {
+		config.Producer.Flush.Messages = p.FlushMessages
+	}
+	if p.FlushBytes > 0 {
+		config.Producer.Flush.Bytes = p.FlushBytes
+	}
+	switch strings.ToLower(p.Compression) {
+	case "", "none":
+		config.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		config.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return fmt.Errorf("不支持的kafka压缩方式:%s", p.Compression)
+	}
+	return nil
+}
+
 func (k *kafka) getClient() (sarama.Client, error) {
 	k.lock.Lock()
 	defer k.lock.Unlock()
@@ -189,54 +384,70 @@ func (k *kafka) getProducer() (sarama.SyncProducer, error) {
 	return k.producer, nil
 }
 
-func (k *kafka) clean() {
-	time.Sleep(time.Second)
-	k.lost()
-	k.connect()
-}
-
-func (k *kafka) lost() {
+func (k *kafka) getAsyncProducer() (sarama.AsyncProducer, error) {
+	if k.client == nil {
+		return nil, fmt.Errorf("客户端为空")
+	}
 	k.lock.Lock()
 	defer k.lock.Unlock()
+	if k.asyncProducer == nil {
+		producer, err := sarama.NewAsyncProducerFromClient(k.client)
+		if err != nil {
+			return nil, fmt.Errorf("创建生产者错误:%w", err)
+		}
+		go func() {
+			for err := range producer.Errors() {
+				logger.Errorf("异步发送消息错误,topic:%s,错误:%v", err.Msg.Topic, err.Err)
+			}
+		}()
+		k.asyncProducer = producer
+	}
+	return k.asyncProducer, nil
+}
+
+// markLost 消费错误时通知断开,恢复后在Setup中通知连接
+func (k *kafka) markLost(err error) {
+	if k.disconnected.Swap(true) {
+		return
+	}
+	k.lostError.set(err)
+	k.lock.RLock()
+	defer k.lock.RUnlock()
 	logger.Infof("lost callback")
 	for _, cb := range k.callbacks {
 		if err := cb.Lost(k); err != nil {
 			logger.Fatalf("lost callback err, %s", err)
 		}
 	}
-	return
 }
 
-func (k *kafka) connect() {
-	k.lock.Lock()
-	defer k.lock.Unlock()
+func (k *kafka) markConnected() {
+	if !k.disconnected.Swap(false) {
+		return
+	}
+	k.lock.RLock()
+	defer k.lock.RUnlock()
 	logger.Infof("connect callback")
 	for _, cb := range k.callbacks {
 		if err := cb.Connect(k); err != nil {
 			logger.Fatalf("connect callback err, %s", err)
 		}
 	}
-	return
 }
 
 type kafkaHandler struct {
-	splitN      int
-	handler     Handler
-	k           *kafka
-	topicParams []string
-	topicString string
-	cancel      context.CancelFunc
-	errChan     chan error
+	group *kafkaGroup
+	k     *kafka
 }
 
 func (h *kafkaHandler) Setup(sess sarama.ConsumerGroupSession) error {
-	logger.Infof("kafka handler setup,topic:%+v,MemberID:%s,GenerationID:%d", h.topicParams, sess.MemberID(), sess.GenerationID())
+	logger.Infof("kafka handler setup,topic:%s,MemberID:%s,GenerationID:%d", h.group.topic, sess.MemberID(), sess.GenerationID())
+	h.k.markConnected()
 	return nil
 }
 
 func (h *kafkaHandler) Cleanup(sess sarama.ConsumerGroupSession) error {
-	logger.Infof("kafka handler cleanup,topic:%+v,MemberID:%s,GenerationID:%d", h.topicParams, sess.MemberID(), sess.GenerationID())
-	h.cancel()
+	logger.Infof("kafka handler cleanup,topic:%s,MemberID:%s,GenerationID:%d", h.group.topic, sess.MemberID(), sess.GenerationID())
 	return nil
 }
 
@@ -247,6 +458,7 @@ func (h *kafkaHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sara
 	return nil
 }
 
+// handlerMessage 分发给所有匹配的订阅后确认消息
 func (h *kafkaHandler) handlerMessage(sess sarama.ConsumerGroupSession, _ sarama.ConsumerGroupClaim, msg *sarama.ConsumerMessage) {
 	if msg == nil {
 		return
@@ -255,43 +467,76 @@ func (h *kafkaHandler) handlerMessage(sess sarama.ConsumerGroupSession, _ sarama
 		sess.MarkMessage(msg, "")
 	}()
 	topic := strings.Join([]string{msg.Topic, string(msg.Key)}, TOPICSEPWITHMQTT)
-	if h.mqttMatch(h.topicString, topic) {
-		h.handler(topic, strings.SplitN(topic, TOPICSEPWITHMQTT, h.splitN), msg.Value)
+	subs := h.group.subs.match(topic)
+	if len(subs) == 0 {
+		return
+	}
+	payload, ok := decompressPayload(topic, kafkaHeader(msg.Headers, HeaderContentEncoding), msg.Value)
+	if !ok {
+		return
+	}
+	for _, sub := range subs {
+		sub.handler(topic, strings.SplitN(topic, TOPICSEPWITHMQTT, sub.splitN), payload)
 	}
 }
 
-func (h *kafkaHandler) mqttMatch(subscription, topic string) bool {
-	subscription = strings.ReplaceAll(subscription, "+", "[^/]+")
-	subscription = strings.ReplaceAll(subscription, "#", ".*")
-	subscription = "^" + subscription + "$"
-
-	match, _ := regexp.MatchString(subscription, topic)
-	return match
+// kafkaHeader 查询消息头,不存在时返回空
+func kafkaHeader(headers []*sarama.RecordHeader, key string) string {
+	for _, h := range headers {
+		if h != nil && string(h.Key) == key {
+			return string(h.Value)
+		}
+	}
+	return ""
 }
 
-func (h *kafkaHandler) consume(ctx context.Context) error {
-	consumer, err := sarama.NewConsumerGroupFromClient(h.k.config.GroupID, h.k.client)
-	if err != nil {
-		return fmt.Errorf("创建消费者错误:%w", err)
-	}
-	topic := h.topicParams[0]
+// consume 消费直到主题的订阅全部取消,每次重新平衡或出错后重新加入消费组
+func (h *kafkaHandler) consume(ctx context.Context) {
+	defer close(h.group.done)
+	topic := h.group.topic
 	go func() {
-		select {
-		case <-ctx.Done():
-			logger.Infof("订阅数据,上下文消费错误停止,topic:%s", topic)
-			return
-		case err := <-consumer.Errors():
+		for err := range h.group.consumer.Errors() {
 			logger.Errorf("订阅数据,收到错误,topic:%s,错误:%v", topic, err)
-			h.cancel()
-			h.errChan <- err
 		}
 	}()
-	go func() {
-		logger.Infof("订阅数据,topic:%s", topic)
-		if err := consumer.Consume(ctx, []string{topic}, h); err != nil {
+	logger.Infof("订阅数据,topic:%s", topic)
+	for {
+		err := h.group.consumer.Consume(ctx, []string{topic}, h)
+		if ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+			logger.Infof("订阅数据,发起停止,topic:%s", topic)
+			return
+		}
+		if err != nil {
 			logger.Errorf("订阅数据,消费错误,topic:%s,错误:%v", topic, err)
-			h.errChan <- err
+			h.k.markLost(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Second):
+			}
 		}
-	}()
+	}
+}
+
+// scramClient kafka SCRAM认证
+type scramClient struct {
+	hash         scram.HashGeneratorFcn
+	conversation *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
 	return nil
 }
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
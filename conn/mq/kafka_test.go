@@ -0,0 +1,185 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/xdg-go/scram"
+)
+
+func TestKafka_GetConfig(t *testing.T) {
+	k := &kafka{config: KafkaConfig{
+		Brokers:  []string{"localhost:9092"},
+		Version:  "2.1.0",
+		SASL:     KafkaSASLConfig{Enable: true, Mechanism: "scram-sha-512", Username: "u", Password: "p"},
+		Producer: KafkaProducerConfig{Async: true, Compression: "zstd", FlushMessages: 100},
+	}}
+	config, err := k.getConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Net.SASL.Mechanism != sarama.SASLTypeSCRAMSHA512 || config.Net.SASL.SCRAMClientGeneratorFunc == nil {
+		t.Errorf("SASL认证方式应为SCRAM-SHA-512,实际为=%s", config.Net.SASL.Mechanism)
+	}
+	if config.Producer.Compression != sarama.CompressionZSTD || config.Producer.Flush.Messages != 100 {
+		t.Errorf("生产者配置错误: %+v", config.Producer)
+	}
+	if config.Producer.Return.Successes {
+		t.Error("异步发送不应返回发送成功的消息")
+	}
+	if err := config.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	k.config.SASL.Mechanism = "GSSAPI"
+	if _, err := k.getConfig(); err == nil {
+		t.Error("不支持的SASL认证方式应返回错误")
+	}
+}
+
+func TestScramClient(t *testing.T) {
+	c := &scramClient{hash: scram.SHA256}
+	if err := c.Begin("user", "pencil", ""); err != nil {
+		t.Fatal(err)
+	}
+	first, err := c.Step("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first) == 0 || c.Done() {
+		t.Errorf("首次认证消息错误: %s", first)
+	}
+}
+
+// newMockKafka 单分区的kafka,fetch不为空时同时模拟消费者组
+func newMockKafka(t *testing.T, topic string, fetch *sarama.MockFetchResponse) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	handlers := map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader(topic, 0, broker.BrokerID()),
+		"ProduceRequest": sarama.NewMockProduceResponse(t),
+	}
+	if fetch != nil {
+		handlers["FindCoordinatorRequest"] = sarama.NewMockFindCoordinatorResponse(t).SetCoordinator(sarama.CoordinatorGroup, "g1", broker)
+		handlers["JoinGroupRequest"] = sarama.NewMockJoinGroupResponse(t).SetGroupProtocol(sarama.RangeBalanceStrategyName)
+		// 消费者组中只有第一个成员分配到分区
+		handlers["SyncGroupRequest"] = sarama.NewMockSequence(
+			sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{topic: {0}},
+			}),
+			sarama.NewMockSyncGroupResponse(t).SetMemberAssignment(&sarama.ConsumerGroupMemberAssignment{
+				Topics: map[string][]int32{},
+			}),
+		)
+		handlers["HeartbeatRequest"] = sarama.NewMockHeartbeatResponse(t)
+		handlers["LeaveGroupRequest"] = sarama.NewMockLeaveGroupResponse(t)
+		handlers["OffsetFetchRequest"] = sarama.NewMockOffsetFetchResponse(t).SetOffset("g1", topic, 0, 0, "", sarama.ErrNoError).SetError(sarama.ErrNoError)
+		handlers["OffsetCommitRequest"] = sarama.NewMockOffsetCommitResponse(t)
+		handlers["OffsetRequest"] = sarama.NewMockOffsetResponse(t).
+			SetOffset(topic, 0, sarama.OffsetOldest, 0).
+			SetOffset(topic, 0, sarama.OffsetNewest, 2)
+		handlers["FetchRequest"] = fetch
+	}
+	broker.SetHandlerByMap(handlers)
+	return broker
+}
+
+func TestKafka_Headers(t *testing.T) {
+	broker := newMockKafka(t, "data", nil)
+	defer broker.Close()
+	for _, version := range []string{"", "2.1.0"} {
+		m, clean, err := NewKafkaClient(KafkaConfig{Brokers: []string{broker.Addr()}, Version: version})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if supported := HeadersSupported(m); supported != (version != "") {
+			t.Errorf("版本=%s,是否支持消息头不匹配: %v", version, supported)
+		}
+		header := Header{HeaderContentType: codecs[CodecMsgpack].ContentType()}
+		if err := PublishWithHeader(context.Background(), m, []string{"data", "p1"}, []byte("1"), header); err != nil {
+			t.Errorf("版本=%s,发送消息错误: %v", version, err)
+		}
+		clean()
+	}
+}
+
+func TestKafka_Consume(t *testing.T) {
+	broker := newMockKafka(t, "data", sarama.NewMockFetchResponse(t, 1).
+		SetMessageWithKey("data", 0, 0, sarama.StringEncoder("p1/d1"), sarama.StringEncoder("1")).
+		SetMessageWithKey("data", 0, 1, sarama.StringEncoder("p2/d1"), sarama.StringEncoder("2")))
+	defer broker.Close()
+	m, clean, err := NewKafkaClient(KafkaConfig{Brokers: []string{broker.Addr()}, Version: "2.0.0", GroupID: "g1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	// 同一kafka主题的订阅共用消费者组,消息分发给所有匹配的订阅
+	received := make(chan string, 10)
+	for _, p := range []string{"p1", "p2", "+"} {
+		p := p
+		if err := m.Consume(context.Background(), []string{"data", p, "#"}, 3, func(topic string, topicSplit []string, payload []byte) {
+			received <- p + ":" + topic
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]bool{"p1:data/p1/d1": true, "p2:data/p2/d1": true, "+:data/p1/d1": true, "+:data/p2/d1": true}
+	for len(want) > 0 {
+		select {
+		case r := <-received:
+			if !want[r] {
+				t.Fatalf("收到不匹配的消息: %s", r)
+			}
+			delete(want, r)
+		case <-time.After(time.Second * 10):
+			t.Fatalf("未收到消息: %v", want)
+		}
+	}
+	if err := m.UnSubscription(context.Background(), []string{"data", "p1", "#"}); err != nil {
+		t.Fatal(err)
+	}
+	if subs := Subscriptions(m); len(subs) != 2 {
+		t.Fatalf("订阅数量应为=2,实际为=%v", subs)
+	}
+}
+
+func TestKafka_ConsumeWithRetry(t *testing.T) {
+	broker := newMockKafka(t, "data", sarama.NewMockFetchResponse(t, 1).
+		SetMessageWithKey("data", 0, 0, sarama.StringEncoder("p1/d1"), sarama.StringEncoder("fail")).
+		SetMessageWithKey("data", 0, 1, sarama.StringEncoder("p1/d2"), sarama.StringEncoder("ok")))
+	defer broker.Close()
+	m, clean, err := NewKafkaClient(KafkaConfig{Brokers: []string{broker.Addr()}, Version: "2.0.0", GroupID: "g1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	// 重试总时长限制为会话超时的一半
+	group := &m.(*kafka).client.Config().Consumer.Group
+	group.Session.Timeout, group.Heartbeat.Interval = time.Second, time.Millisecond*300
+	var attempts atomic.Int32
+	done := make(chan struct{})
+	cfg := RetryConfig{MaxRetries: 100, Backoff: time.Millisecond * 100, MaxBackoff: time.Millisecond * 100}
+	if err := ConsumeWithRetry(context.Background(), m, []string{"data", "#"}, 3, cfg, func(topic string, topicSplit []string, payload []byte) error {
+		if string(payload) == "ok" {
+			close(done)
+			return nil
+		}
+		attempts.Add(1)
+		return errors.New("处理失败")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(time.Second * 10):
+		t.Fatal("重试未停止,后续消息未处理")
+	}
+	if n := attempts.Load(); n < 2 || n > 6 {
+		t.Fatalf("处理次数应受重试总时长限制,实际为=%d", n)
+	}
+}
@@ -0,0 +1,110 @@
+package mq
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/air-iot/logger"
+)
+
+var _ MQ = new(memory)
+
+// MemoryConfig 内存消息队列配置参数
+type MemoryConfig struct {
+	BufferSize int `json:"bufferSize" yaml:"bufferSize"` // 每个订阅的消息缓冲数量
+}
+
+type memory struct {
+	subscriptionRegistry[*memorySubscription]
+	lock      sync.RWMutex
+	config    MemoryConfig
+	callbacks []Callback
+}
+
+type memoryMessage struct {
+	topic   string
+	payload []byte
+}
+
+type memorySubscription struct {
+	splitN   int
+	handler  Handler
+	messages chan *memoryMessage
+	done     chan struct{}
+}
+
+// NewMemoryClient 创建进程内消息队列,topic格式和通配符与MQTT一致
+func NewMemoryClient(cfg MemoryConfig) (MQ, func(), error) {
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 1024
+	}
+	m := &memory{config: cfg, callbacks: make([]Callback, 0)}
+	cleanFunc := func() {
+		for _, sub := range m.clear() {
+			close(sub.done)
+		}
+	}
+	return m, cleanFunc, nil
+}
+
+func (m *memory) Callback(cb Callback) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.callbacks = append(m.callbacks, cb)
+}
+
+func (m *memory) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	msg := &memoryMessage{topic: topic, payload: payload}
+	for _, sub := range m.match(topic) {
+		select {
+		case sub.messages <- msg:
+		case <-sub.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (m *memory) Consume(_ context.Context, topicParams []string, splitN int, handler Handler) error {
+	sub := &memorySubscription{
+		splitN:   splitN,
+		handler:  handler,
+		messages: make(chan *memoryMessage, m.config.BufferSize),
+		done:     make(chan struct{}),
+	}
+	if old, ok := m.add(topicParams, splitN, sub); ok {
+		close(old.done)
+	}
+	go sub.run()
+	return nil
+}
+
+func (m *memory) UnSubscription(_ context.Context, topicParams []string) error {
+	if sub, ok := m.remove(topicParams); ok {
+		close(sub.done)
+	}
+	return nil
+}
+
+func (s *memorySubscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case msg := <-s.messages:
+			s.handle(msg)
+		}
+	}
+}
+
+func (s *memorySubscription) handle(msg *memoryMessage) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("内存消息队列: topic=%s. 处理消息错误: %v", msg.topic, r)
+		}
+	}()
+	s.handler(msg.topic, strings.SplitN(msg.topic, TOPICSEPWITHMQTT, s.splitN), msg.payload)
+}
@@ -0,0 +1,79 @@
+package mq
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		subscription string
+		topic        string
+		want         bool
+	}{
+		{"data/p1/t1/d1", "data/p1/t1/d1", true},
+		{"data/p1/t1/d1", "data/p1/t1/d2", false},
+		{"data/+/t1/+", "data/p1/t1/d1", true},
+		{"data/+/t1", "data/p1/t1/d1", false},
+		{"data/#", "data/p1/t1/d1", true},
+		{"data/#", "data", true},
+		{"#", "data/p1", true},
+		{"logs/+/#", "data/p1/t1", false},
+		{"data/#/t1", "data/p1/t1", false},
+	}
+	for _, tt := range tests {
+		if got := MatchTopic(tt.subscription, tt.topic); got != tt.want {
+			t.Errorf("MatchTopic(%s, %s) = %v, want %v", tt.subscription, tt.topic, got, tt.want)
+		}
+	}
+}
+
+func TestMemory_Consume(t *testing.T) {
+	cli, clean, err := NewMQ(Config{Type: "memory"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	ctx := context.Background()
+	ch := make(chan []string, 10)
+	if err := cli.Consume(ctx, []string{"data", "+", "#"}, 3, func(topic string, topicSplit []string, payload []byte) {
+		ch <- topicSplit
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"logs", "p1"}, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case topicSplit := <-ch:
+		if want := []string{"data", "p1", "t1/d1"}; !reflect.DeepEqual(topicSplit, want) {
+			t.Fatalf("topic分割不匹配,应为=%v,实际为=%v", want, topicSplit)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("未收到消息")
+	}
+
+	if subs := Subscriptions(cli); len(subs) != 1 || subs[0].SplitN != 3 || !reflect.DeepEqual(subs[0].Topic, []string{"data", "+", "#"}) {
+		t.Fatalf("订阅记录错误: %+v", subs)
+	}
+
+	if err := cli.UnSubscription(ctx, []string{"data", "+", "#"}); err != nil {
+		t.Fatal(err)
+	}
+	if subs := Subscriptions(cli); len(subs) != 0 {
+		t.Fatalf("取消订阅后应删除订阅记录: %+v", subs)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case topicSplit := <-ch:
+		t.Fatalf("取消订阅后不应收到消息: %v", topicSplit)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
@@ -1,6 +1,11 @@
 package mq
 
-import "context"
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+)
 
 type Handler func(topic string, topicSplit []string, payload []byte)
 
@@ -12,7 +17,200 @@ type MQ interface {
 	Callback(Callback)
 }
 
+// Header 消息元数据
+type Header map[string]string
+
+// HeaderContentType 消息内容的编码类型,见Codec.ContentType
+const HeaderContentType = "content-type"
+
+// headerPublisher 支持发送消息元数据的消息队列
+type headerPublisher interface {
+	PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error
+}
+
+// headerSupporter 是否支持消息元数据取决于版本或服务端的消息队列
+type headerSupporter interface {
+	HeadersSupported() bool
+}
+
+// PublishWithHeader 发送带元数据的消息.MQTT v5使用消息属性,RabbitMQ使用消息属性和消息头,Kafka和NATS使用消息头,
+// Redis使用stream字段;不支持元数据时(见HeadersSupported)只发送消息内容
+func PublishWithHeader(ctx context.Context, m MQ, topicParams []string, payload []byte, header Header) error {
+	if p, ok := m.(headerPublisher); ok {
+		return p.PublishWithHeader(ctx, topicParams, payload, header)
+	}
+	return m.Publish(ctx, topicParams, payload)
+}
+
+// HeadersSupported 消息队列是否支持发送消息元数据.MQTT v3/v4、内存消息队列、版本低于0.11的Kafka
+// 以及服务端不支持消息头的NATS不支持,此时只能使用json编码且不压缩
+func HeadersSupported(m MQ) bool {
+	if _, ok := m.(headerPublisher); !ok {
+		return false
+	}
+	if s, ok := m.(headerSupporter); ok {
+		return s.HeadersSupported()
+	}
+	return true
+}
+
+// Subscription 订阅信息,消息队列重连后自动恢复
+type Subscription struct {
+	Topic  []string `json:"topic"`
+	SplitN int      `json:"splitN"`
+}
+
+// Subscriptions 查询消息队列当前的订阅,按主题排序,不支持时返回nil
+func Subscriptions(m MQ) []Subscription {
+	if s, ok := m.(interface{ Subscriptions() []Subscription }); ok {
+		return s.Subscriptions()
+	}
+	return nil
+}
+
+// subscriptionRegistry 订阅记录,按MQTT格式的主题保存订阅及消息队列自己的订阅信息,
+// 嵌入到消息队列中提供Subscriptions
+type subscriptionRegistry[T any] struct {
+	subLock sync.RWMutex
+	subs    map[string]*registeredSub[T]
+}
+
+type registeredSub[T any] struct {
+	sub     Subscription
+	matcher TopicMatcher
+	value   T
+}
+
+// add 保存订阅,返回同一主题原有的订阅
+func (r *subscriptionRegistry[T]) add(topicParams []string, splitN int, value T) (old T, ok bool) {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	if r.subs == nil {
+		r.subs = map[string]*registeredSub[T]{}
+	}
+	if s, exists := r.subs[topic]; exists {
+		old, ok = s.value, true
+	}
+	r.subs[topic] = &registeredSub[T]{
+		sub:     Subscription{Topic: append([]string{}, topicParams...), SplitN: splitN},
+		matcher: NewTopicMatcher(topic),
+		value:   value,
+	}
+	return old, ok
+}
+
+// get 查询主题的订阅
+func (r *subscriptionRegistry[T]) get(topicParams []string) (value T, ok bool) {
+	r.subLock.RLock()
+	defer r.subLock.RUnlock()
+	if s, exists := r.subs[strings.Join(topicParams, TOPICSEPWITHMQTT)]; exists {
+		return s.value, true
+	}
+	return value, false
+}
+
+// remove 删除并返回主题的订阅
+func (r *subscriptionRegistry[T]) remove(topicParams []string) (value T, ok bool) {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	if s, exists := r.subs[topic]; exists {
+		delete(r.subs, topic)
+		return s.value, true
+	}
+	return value, false
+}
+
+// clear 删除并返回所有订阅
+func (r *subscriptionRegistry[T]) clear() []T {
+	r.subLock.Lock()
+	defer r.subLock.Unlock()
+	values := make([]T, 0, len(r.subs))
+	for _, s := range r.sorted() {
+		values = append(values, s.value)
+	}
+	r.subs = nil
+	return values
+}
+
+// list 所有订阅,按主题排序
+func (r *subscriptionRegistry[T]) list() []T {
+	r.subLock.RLock()
+	defer r.subLock.RUnlock()
+	values := make([]T, 0, len(r.subs))
+	for _, s := range r.sorted() {
+		values = append(values, s.value)
+	}
+	return values
+}
+
+// match 与消息主题匹配的订阅
+func (r *subscriptionRegistry[T]) match(topic string) []T {
+	r.subLock.RLock()
+	defer r.subLock.RUnlock()
+	values := make([]T, 0, 1)
+	for _, s := range r.subs {
+		if s.matcher.Match(topic) {
+			values = append(values, s.value)
+		}
+	}
+	return values
+}
+
+// Subscriptions 当前的订阅
+func (r *subscriptionRegistry[T]) Subscriptions() []Subscription {
+	r.subLock.RLock()
+	defer r.subLock.RUnlock()
+	subs := make([]Subscription, 0, len(r.subs))
+	for _, s := range r.sorted() {
+		subs = append(subs, s.sub)
+	}
+	return subs
+}
+
+// sorted 按主题排序的订阅,调用时需持有锁
+func (r *subscriptionRegistry[T]) sorted() []*registeredSub[T] {
+	topics := make([]string, 0, len(r.subs))
+	for topic := range r.subs {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	subs := make([]*registeredSub[T], len(topics))
+	for i, topic := range topics {
+		subs[i] = r.subs[topic]
+	}
+	return subs
+}
+
 type Callback interface {
 	Connect(MQ) error
 	Lost(MQ) error
 }
+
+// LostErr 查询连接断开的原因,在Callback.Lost中调用.MQTT v5 服务端断开时为*ReasonCodeError,不支持时返回nil
+func LostErr(m MQ) error {
+	if l, ok := m.(interface{ LostErr() error }); ok {
+		return l.LostErr()
+	}
+	return nil
+}
+
+// lostError 保存最近一次连接断开的原因
+type lostError struct {
+	errLock sync.Mutex
+	err     error
+}
+
+func (l *lostError) set(err error) {
+	l.errLock.Lock()
+	defer l.errLock.Unlock()
+	l.err = err
+}
+
+// LostErr 最近一次连接断开的原因
+func (l *lostError) LostErr() error {
+	l.errLock.Lock()
+	defer l.errLock.Unlock()
+	return l.err
+}
@@ -10,12 +10,24 @@ import (
 	MQTT "github.com/eclipse/paho.mqtt.golang"
 
 	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
 )
 
 type mqtt struct {
+	lostError
+	// subscriptionRegistry 订阅记录,使用单独的锁,回调中可以订阅
+	subscriptionRegistry[*mqttSub]
 	lock      sync.RWMutex
 	client    MQTT.Client
 	callbacks []Callback
+	cfg       MQTTConfig
+}
+
+type mqttSub struct {
+	topicParams []string
+	splitN      int
+	handler     Handler
 }
 
 // MQTTConfig mqtt配置参数
@@ -26,11 +38,85 @@ type MQTTConfig struct {
 	Password        string `json:"password" yaml:"password"`
 	KeepAlive       uint   `json:"keepAlive" yaml:"keepAlive" default:"60"`
 	ConnectTimeout  uint   `json:"connectTimeout" yaml:"connectTimeout" default:"20"`
-	ProtocolVersion uint   `json:"protocolVersion" yaml:"protocolVersion" default:"4"`
+	ProtocolVersion uint   `json:"protocolVersion" yaml:"protocolVersion" default:"4"` // 3、4或5
+	ClientID        string `json:"clientId" yaml:"clientId"`
+	// Scheme 连接协议 tcp、ssl、ws、wss,为空时启用TLS使用ssl,否则使用tcp
+	Scheme string      `json:"scheme" yaml:"scheme"`
+	Path   string      `json:"path" yaml:"path"` // ws和wss的路径,默认/mqtt
+	TLS    tlsx.Config `json:"tls" yaml:"tls"`
+	// QoS和Retain 默认的消息质量和保留消息
+	QoS    byte `json:"qos" yaml:"qos"`
+	Retain bool `json:"retain" yaml:"retain"`
+	// Topics 按主题类别(主题的第一级,如data、warningStorage、logs)配置消息质量和保留消息
+	Topics map[string]MQTTTopicConfig `json:"topics" yaml:"topics"`
+	// ShareGroup 共享订阅分组,不为空时订阅$share/{ShareGroup}/{topic},同一分组的多个实例分摊消息
+	ShareGroup string `json:"shareGroup" yaml:"shareGroup"`
+	// Expiry MQTT v5 消息过期时间(秒),0为不过期
+	Expiry uint32 `json:"expiry" yaml:"expiry"`
+	// UserProperties MQTT v5 按主题各级依次命名的用户属性,默认为class、project、table、device,名称为空的级别不发送
+	UserProperties []string `json:"userProperties" yaml:"userProperties"`
+}
+
+// MQTTTopicConfig 主题类别的消息质量和保留消息
+type MQTTTopicConfig struct {
+	QoS    byte `json:"qos" yaml:"qos"`
+	Retain bool `json:"retain" yaml:"retain"`
+	// Expiry和UserProperties 为空时使用全局配置
+	Expiry         uint32   `json:"expiry" yaml:"expiry"`
+	UserProperties []string `json:"userProperties" yaml:"userProperties"`
 }
 
+var defaultUserProperties = []string{"class", "project", "table", "device"}
+
 func (a MQTTConfig) DNS() string {
-	return fmt.Sprintf("tcp://%s:%d", a.Host, a.Port)
+	scheme := strings.ToLower(a.Scheme)
+	if scheme == "" {
+		scheme = "tcp"
+		if a.TLS.Enable {
+			scheme = "ssl"
+		}
+	}
+	switch scheme {
+	case "ws", "wss":
+		path := a.Path
+		if path == "" {
+			path = "/mqtt"
+		}
+		return fmt.Sprintf("%s://%s:%d%s", scheme, a.Host, a.Port, path)
+	default:
+		return fmt.Sprintf("%s://%s:%d", scheme, a.Host, a.Port)
+	}
+}
+
+// topicConfig 主题的消息质量和保留消息,主题类别不区分大小写
+func (a MQTTConfig) topicConfig(topicParams []string) MQTTTopicConfig {
+	cfg := MQTTTopicConfig{QoS: a.QoS, Retain: a.Retain}
+	if len(topicParams) > 0 {
+		for class, topicCfg := range a.Topics {
+			if strings.EqualFold(class, topicParams[0]) {
+				cfg = topicCfg
+				break
+			}
+		}
+	}
+	if cfg.Expiry == 0 {
+		cfg.Expiry = a.Expiry
+	}
+	if len(cfg.UserProperties) == 0 {
+		cfg.UserProperties = a.UserProperties
+	}
+	if len(cfg.UserProperties) == 0 {
+		cfg.UserProperties = defaultUserProperties
+	}
+	return cfg
+}
+
+// shareTopic 配置了共享订阅分组时返回共享订阅主题
+func (a MQTTConfig) shareTopic(topic string) string {
+	if a.ShareGroup == "" {
+		return topic
+	}
+	return "$share/" + a.ShareGroup + TOPICSEPWITHMQTT + topic
 }
 
 const TOPICSEPWITHMQTT = "/"
@@ -43,26 +129,61 @@ func NewMQTT(cli MQTT.Client) MQ {
 
 // NewMQTTClient 创建MQTT消息队列
 func NewMQTTClient(cfg MQTTConfig) (MQ, func(), error) {
+	if cfg.KeepAlive == 0 {
+		cfg.KeepAlive = 60
+	}
+	if cfg.ConnectTimeout == 0 {
+		cfg.ConnectTimeout = 20
+	}
+	if cfg.ProtocolVersion == 0 {
+		cfg.ProtocolVersion = 4
+	}
+	if cfg.ProtocolVersion < 3 || cfg.ProtocolVersion > 5 {
+		return nil, nil, fmt.Errorf("不支持的MQTT协议版本: %d", cfg.ProtocolVersion)
+	}
+	if cfg.QoS > 2 {
+		return nil, nil, fmt.Errorf("MQTT消息质量错误: %d", cfg.QoS)
+	}
+	for class, topicCfg := range cfg.Topics {
+		if topicCfg.QoS > 2 {
+			return nil, nil, fmt.Errorf("MQTT主题类别 %s 消息质量错误: %d", class, topicCfg.QoS)
+		}
+	}
+	tlsCfg, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	if cfg.ProtocolVersion == 5 {
+		return newMQTT5Client(cfg, tlsCfg)
+	}
 	mqCli := new(mqtt)
+	mqCli.cfg = cfg
 	mqCli.callbacks = make([]Callback, 0)
 	opts := MQTT.NewClientOptions()
 	opts.AddBroker(cfg.DNS())
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
 	opts.SetAutoReconnect(true)
 	opts.SetCleanSession(true)
 	opts.SetUsername(cfg.Username)
 	opts.SetPassword(cfg.Password)
-	opts.SetConnectTimeout(time.Second * 20)
-	opts.SetKeepAlive(time.Second * 60)
-	opts.SetProtocolVersion(4)
+	opts.SetClientID(cfg.ClientID)
+	opts.SetConnectTimeout(time.Second * time.Duration(cfg.ConnectTimeout))
+	opts.SetKeepAlive(time.Second * time.Duration(cfg.KeepAlive))
+	opts.SetProtocolVersion(cfg.ProtocolVersion)
 	opts.SetConnectionLostHandler(func(client MQTT.Client, e error) {
 		if e != nil {
 			logger.Errorf("MQTT Lost错误: %s", e.Error())
+			mqCli.lostError.set(e)
 			mqCli.lost()
 		}
 	})
 	opts.SetOrderMatters(false)
 	opts.SetOnConnectHandler(func(client MQTT.Client) {
 		logger.Infof("MQTT 已连接")
+		// 清除会话后服务端不保留订阅,重连后恢复
+		mqCli.resubscribe(client)
 		mqCli.connect()
 	})
 	// Start the connection
@@ -108,25 +229,45 @@ func (p *mqtt) connect() {
 
 func (p *mqtt) Publish(ctx context.Context, topicParams []string, payload []byte) error {
 	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
-	if token := p.client.Publish(topic, 0, false, string(payload)); token.Wait() && token.Error() != nil {
+	topicCfg := p.cfg.topicConfig(topicParams)
+	if token := p.client.Publish(topic, topicCfg.QoS, topicCfg.Retain, string(payload)); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
 }
 
 func (p *mqtt) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
-	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
-	if token := p.client.Subscribe(topic, 0, func(client MQTT.Client, message MQTT.Message) {
-		handler(message.Topic(), strings.SplitN(message.Topic(), TOPICSEPWITHMQTT, splitN), message.Payload())
+	sub := &mqttSub{topicParams: append([]string{}, topicParams...), splitN: splitN, handler: handler}
+	if err := p.subscribe(p.client, sub); err != nil {
+		return err
+	}
+	p.add(topicParams, splitN, sub)
+	return nil
+}
+
+func (p *mqtt) subscribe(client MQTT.Client, sub *mqttSub) error {
+	topic := strings.Join(sub.topicParams, TOPICSEPWITHMQTT)
+	if token := client.Subscribe(p.cfg.shareTopic(topic), p.cfg.topicConfig(sub.topicParams).QoS, func(client MQTT.Client, message MQTT.Message) {
+		sub.handler(message.Topic(), strings.SplitN(message.Topic(), TOPICSEPWITHMQTT, sub.splitN), message.Payload())
 	}); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
 }
 
+// resubscribe 重连后恢复订阅
+func (p *mqtt) resubscribe(client MQTT.Client) {
+	for _, sub := range p.list() {
+		if err := p.subscribe(client, sub); err != nil {
+			logger.Errorf("MQTT 恢复订阅错误: 主题=%s. %s", strings.Join(sub.topicParams, TOPICSEPWITHMQTT), err.Error())
+		}
+	}
+}
+
 func (p *mqtt) UnSubscription(ctx context.Context, topicParams []string) error {
 	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
-	if token := p.client.Unsubscribe(topic); token.Wait() && token.Error() != nil {
+	p.remove(topicParams)
+	if token := p.client.Unsubscribe(p.cfg.shareTopic(topic)); token.Wait() && token.Error() != nil {
 		return token.Error()
 	}
 	return nil
@@ -0,0 +1,365 @@
+package mq
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
+
+	"github.com/air-iot/logger"
+)
+
+var _ MQ = new(mqtt5)
+
+// UserProperty MQTT v5 用户属性
+type UserProperty struct {
+	Key   string
+	Value string
+}
+
+// ReasonCodeError MQTT v5 原因码错误
+type ReasonCodeError struct {
+	Code   byte
+	Reason string // 服务端返回的原因字符串
+}
+
+var reasonCodeNames = map[byte]string{
+	0x00: "Normal disconnection",
+	0x04: "Disconnect with Will Message",
+	0x80: "Unspecified error",
+	0x81: "Malformed Packet",
+	0x82: "Protocol Error",
+	0x83: "Implementation specific error",
+	0x84: "Unsupported Protocol Version",
+	0x85: "Client Identifier not valid",
+	0x86: "Bad User Name or Password",
+	0x87: "Not authorized",
+	0x88: "Server unavailable",
+	0x89: "Server busy",
+	0x8A: "Banned",
+	0x8B: "Server shutting down",
+	0x8C: "Bad authentication method",
+	0x8D: "Keep Alive timeout",
+	0x8E: "Session taken over",
+	0x8F: "Topic Filter invalid",
+	0x90: "Topic Name invalid",
+	0x91: "Packet Identifier in use",
+	0x92: "Packet Identifier not found",
+	0x93: "Receive Maximum exceeded",
+	0x94: "Topic Alias invalid",
+	0x95: "Packet too large",
+	0x96: "Message rate too high",
+	0x97: "Quota exceeded",
+	0x98: "Administrative action",
+	0x99: "Payload format invalid",
+	0x9A: "Retain not supported",
+	0x9B: "QoS not supported",
+	0x9C: "Use another server",
+	0x9D: "Server moved",
+	0x9E: "Shared Subscriptions not supported",
+	0x9F: "Connection rate exceeded",
+	0xA0: "Maximum connect time",
+	0xA1: "Subscription Identifiers not supported",
+	0xA2: "Wildcard Subscriptions not supported",
+}
+
+func (e *ReasonCodeError) Error() string {
+	name, ok := reasonCodeNames[e.Code]
+	if !ok {
+		name = "Unknown"
+	}
+	if e.Reason != "" {
+		return fmt.Sprintf("MQTT原因码 0x%02X(%s): %s", e.Code, name, e.Reason)
+	}
+	return fmt.Sprintf("MQTT原因码 0x%02X(%s)", e.Code, name)
+}
+
+// reasonError 原因码小于0x80为成功,返回nil
+func reasonError(code byte, reason string) error {
+	if code < 0x80 {
+		return nil
+	}
+	return &ReasonCodeError{Code: code, Reason: reason}
+}
+
+// mqtt5Sub 订阅信息,断线重连后重新订阅
+type mqtt5Sub struct {
+	filter  string // 实际订阅的主题,共享订阅时带$share/{group}/前缀
+	qos     byte
+	splitN  int
+	handler Handler
+}
+
+// mqtt5 MQTT v5 客户端,连接、重连、会话状态和流量控制由autopaho处理
+type mqtt5 struct {
+	lostError
+	subscriptionRegistry[*mqtt5Sub]
+	cfg       MQTTConfig
+	cm        *autopaho.ConnectionManager
+	lock      sync.RWMutex
+	callbacks []Callback
+
+	connLock sync.Mutex
+	maxQoS   byte
+	connErr  error // 最近一次连接错误
+}
+
+func newMQTT5Client(cfg MQTTConfig, tlsCfg *tls.Config) (MQ, func(), error) {
+	u, err := url.Parse(cfg.DNS())
+	if err != nil {
+		return nil, nil, fmt.Errorf("MQTT连接地址错误: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp", "mqtt", "ssl", "tls", "mqtts", "ws", "wss":
+	default:
+		return nil, nil, fmt.Errorf("不支持的MQTT连接协议: %s", u.Scheme)
+	}
+	c := &mqtt5{cfg: cfg, callbacks: make([]Callback, 0), maxQoS: 2}
+	timeout := time.Second * time.Duration(cfg.ConnectTimeout)
+	cm, err := autopaho.NewConnection(context.Background(), autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        tlsCfg,
+		KeepAlive:                     uint16(cfg.KeepAlive),
+		CleanStartOnInitialConnection: true,
+		ConnectRetryDelay:             time.Second,
+		ConnectTimeout:                timeout,
+		ConnectUsername:               cfg.Username,
+		ConnectPassword:               []byte(cfg.Password),
+		OnConnectionUp:                c.connected,
+		OnConnectError:                c.connectError,
+		ClientConfig: paho.ClientConfig{
+			ClientID: cfg.ClientID,
+			// 消息在同一个协程中依次处理,处理完成后才确认,保证顺序并由接收最大值限制未确认的消息数
+			OnPublishReceived:  []func(paho.PublishReceived) (bool, error){c.deliver},
+			OnClientError:      c.clientError,
+			OnServerDisconnect: c.serverDisconnect,
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建MQTT客户端错误: %w", err)
+	}
+	c.cm = cm
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := cm.AwaitConnection(ctx); err != nil {
+		_ = cm.Disconnect(context.Background())
+		c.connLock.Lock()
+		defer c.connLock.Unlock()
+		if c.connErr != nil {
+			return nil, nil, c.connErr
+		}
+		return nil, nil, fmt.Errorf("MQTT连接超时: %w", err)
+	}
+	cleanFunc := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		_ = cm.Disconnect(ctx)
+	}
+	return c, cleanFunc, nil
+}
+
+// connectError 连接失败,服务端拒绝连接时转换为原因码错误
+func (c *mqtt5) connectError(err error) {
+	var connackErr *autopaho.ConnackError
+	if errors.As(err, &connackErr) {
+		err = &ReasonCodeError{Code: connackErr.ReasonCode, Reason: connackErr.Reason}
+	}
+	logger.Errorf("MQTT 连接错误: %s", err.Error())
+	c.connLock.Lock()
+	defer c.connLock.Unlock()
+	c.connErr = err
+}
+
+func (c *mqtt5) clientError(err error) {
+	logger.Errorf("MQTT Lost错误: %s", err.Error())
+	c.lostError.set(err)
+	c.lost()
+}
+
+// serverDisconnect 服务端断开连接,断开原因为服务端返回的原因码
+func (c *mqtt5) serverDisconnect(d *paho.Disconnect) {
+	e := &ReasonCodeError{Code: d.ReasonCode}
+	if d.Properties != nil {
+		e.Reason = d.Properties.ReasonString
+	}
+	c.clientError(e)
+}
+
+// connected 连接成功后重新订阅并通知回调
+func (c *mqtt5) connected(_ *autopaho.ConnectionManager, connack *paho.Connack) {
+	logger.Infof("MQTT 已连接")
+	c.connLock.Lock()
+	c.maxQoS = 2
+	if connack.Properties != nil && connack.Properties.MaximumQoS != nil {
+		c.maxQoS = *connack.Properties.MaximumQoS
+	}
+	c.connErr = nil
+	c.connLock.Unlock()
+	for _, sub := range c.list() {
+		if err := c.subscribe(context.Background(), sub); err != nil {
+			logger.Errorf("MQTT 重新订阅错误: 主题=%s. %s", sub.filter, err.Error())
+		}
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, cb := range c.callbacks {
+		if err := cb.Connect(c); err != nil {
+			logger.Fatalf("connect callback err, %s", err)
+		}
+	}
+}
+
+// deliver 依次调用匹配的订阅处理消息
+func (c *mqtt5) deliver(pr paho.PublishReceived) (bool, error) {
+	p := pr.Packet
+	var encoding string
+	if p.Properties != nil {
+		encoding = p.Properties.User.Get(HeaderContentEncoding)
+	}
+	payload, ok := decompressPayload(p.Topic, encoding, p.Payload)
+	if !ok {
+		return true, nil
+	}
+	for _, sub := range c.match(p.Topic) {
+		c.handle(sub, p.Topic, payload)
+	}
+	return true, nil
+}
+
+func (c *mqtt5) handle(sub *mqtt5Sub, topic string, payload []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("MQTT 消息处理异常: 主题=%s. %v", topic, r)
+		}
+	}()
+	sub.handler(topic, strings.SplitN(topic, TOPICSEPWITHMQTT, sub.splitN), payload)
+}
+
+func (c *mqtt5) Callback(cb Callback) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.callbacks = append(c.callbacks, cb)
+}
+
+func (c *mqtt5) lost() {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for _, cb := range c.callbacks {
+		if err := cb.Lost(c); err != nil {
+			logger.Fatalf("lost callback err, %s", err)
+		}
+	}
+}
+
+// userProperties 按配置的名称把主题各级作为用户属性
+func userProperties(names, topicParams []string) []UserProperty {
+	props := make([]UserProperty, 0, len(names))
+	for i, name := range names {
+		if i >= len(topicParams) {
+			break
+		}
+		if name != "" && topicParams[i] != "" {
+			props = append(props, UserProperty{Key: name, Value: topicParams[i]})
+		}
+	}
+	return props
+}
+
+func (c *mqtt5) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return c.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+// PublishWithHeader 发送消息,content-type使用内容类型属性,其他元数据使用用户属性
+func (c *mqtt5) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
+	topicCfg := c.cfg.topicConfig(topicParams)
+	c.connLock.Lock()
+	qos := topicCfg.QoS
+	if qos > c.maxQoS {
+		qos = c.maxQoS
+	}
+	c.connLock.Unlock()
+	props := &paho.PublishProperties{}
+	for _, u := range userProperties(topicCfg.UserProperties, topicParams) {
+		props.User = append(props.User, paho.UserProperty{Key: u.Key, Value: u.Value})
+	}
+	if topicCfg.Expiry > 0 {
+		props.MessageExpiry = &topicCfg.Expiry
+	}
+	for k, v := range header {
+		if k == HeaderContentType {
+			props.ContentType = v
+			continue
+		}
+		props.User = append(props.User, paho.UserProperty{Key: k, Value: v})
+	}
+	resp, err := c.cm.Publish(ctx, &paho.Publish{
+		QoS:        qos,
+		Retain:     topicCfg.Retain,
+		Topic:      strings.Join(topicParams, TOPICSEPWITHMQTT),
+		Properties: props,
+		Payload:    payload,
+	})
+	if resp != nil {
+		var reason string
+		if resp.Properties != nil {
+			reason = resp.Properties.ReasonString
+		}
+		if err := reasonError(resp.ReasonCode, reason); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (c *mqtt5) subscribe(ctx context.Context, sub *mqtt5Sub) error {
+	suback, err := c.cm.Subscribe(ctx, &paho.Subscribe{
+		Subscriptions: []paho.SubscribeOptions{{Topic: sub.filter, QoS: sub.qos}},
+	})
+	if suback != nil {
+		var reason string
+		if suback.Properties != nil {
+			reason = suback.Properties.ReasonString
+		}
+		for _, code := range suback.Reasons {
+			if err := reasonError(code, reason); err != nil {
+				return err
+			}
+		}
+	}
+	return err
+}
+
+func (c *mqtt5) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	sub := &mqtt5Sub{
+		filter:  c.cfg.shareTopic(topic),
+		qos:     c.cfg.topicConfig(topicParams).QoS,
+		splitN:  splitN,
+		handler: handler,
+	}
+	// 先保存订阅再向服务端订阅,服务端在SUBACK后立即下发的保留消息才能找到处理函数
+	old, exists := c.add(topicParams, splitN, sub)
+	if err := c.subscribe(ctx, sub); err != nil {
+		if exists {
+			c.add(topicParams, old.splitN, old)
+		} else {
+			c.remove(topicParams)
+		}
+		return err
+	}
+	return nil
+}
+
+func (c *mqtt5) UnSubscription(ctx context.Context, topicParams []string) error {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	c.remove(topicParams)
+	_, err := c.cm.Unsubscribe(ctx, &paho.Unsubscribe{Topics: []string{c.cfg.shareTopic(topic)}})
+	return err
+}
@@ -0,0 +1,295 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.golang/packets"
+)
+
+// fakeBroker 测试用的MQTT v5 服务端,只实现客户端用到的报文
+type fakeBroker struct {
+	ln        net.Listener
+	lock      sync.Mutex
+	conn      net.Conn
+	subs      []string
+	retained  map[string]*packets.Publish
+	pubrecs   chan uint16
+	published chan *packets.Properties
+	connects  chan struct{}
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	b := &fakeBroker{retained: map[string]*packets.Publish{}, pubrecs: make(chan uint16, 10), published: make(chan *packets.Properties, 10), connects: make(chan struct{}, 10)}
+	b.ln = listenFakeBroker(t, b.serve)
+	return b
+}
+
+func (b *fakeBroker) write(conn net.Conn, p packets.Packet) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, _ = p.WriteTo(conn)
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		cp, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := cp.Content.(type) {
+		case *packets.Connect:
+			b.lock.Lock()
+			b.conn = conn
+			b.lock.Unlock()
+			b.write(conn, &packets.Connack{Properties: &packets.Properties{ReasonString: "ok"}})
+			b.connects <- struct{}{}
+		case *packets.Subscribe:
+			filter := p.Subscriptions[0].Topic
+			b.lock.Lock()
+			b.subs = append(b.subs, filter)
+			retained := make([]*packets.Publish, 0)
+			for topic, msg := range b.retained {
+				if MatchTopic(filter, topic) {
+					retained = append(retained, msg)
+				}
+			}
+			b.lock.Unlock()
+			code := byte(1)
+			if strings.HasSuffix(filter, "denied") {
+				code = 0x87
+			}
+			b.write(conn, &packets.Suback{PacketID: p.PacketID, Properties: &packets.Properties{}, Reasons: []byte{code}})
+			// 订阅成功后立即下发保留消息
+			for _, msg := range retained {
+				b.write(conn, &packets.Publish{Topic: msg.Topic, Retain: true, Properties: &packets.Properties{}, Payload: msg.Payload})
+			}
+		case *packets.Publish:
+			if p.Retain {
+				b.lock.Lock()
+				b.retained[p.Topic] = p
+				b.lock.Unlock()
+			}
+			b.published <- p.Properties
+			b.write(conn, &packets.Puback{PacketID: p.PacketID, Properties: &packets.Properties{}})
+			b.write(conn, &packets.Publish{Topic: p.Topic, Properties: &packets.Properties{}, Payload: p.Payload})
+		case *packets.Pubrec:
+			b.pubrecs <- p.PacketID
+		case *packets.Pingreq:
+			b.write(conn, &packets.Pingresp{})
+		case *packets.Disconnect:
+			return
+		}
+	}
+}
+
+// send 服务端向客户端发送报文
+func (b *fakeBroker) send(p packets.Packet) {
+	b.lock.Lock()
+	conn := b.conn
+	b.lock.Unlock()
+	b.write(conn, p)
+}
+
+func (b *fakeBroker) subCount() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.subs)
+}
+
+// disconnect 服务端带原因码断开连接
+func (b *fakeBroker) disconnect(code byte, reason string) {
+	b.lock.Lock()
+	conn := b.conn
+	b.lock.Unlock()
+	b.write(conn, &packets.Disconnect{ReasonCode: code, Properties: &packets.Properties{ReasonString: reason}})
+	_ = conn.Close()
+}
+
+type lostCallback struct {
+	lost chan error
+}
+
+func (c *lostCallback) Connect(MQ) error { return nil }
+
+func (c *lostCallback) Lost(m MQ) error {
+	c.lost <- LostErr(m)
+	return nil
+}
+
+func TestMQTT5(t *testing.T) {
+	b := newFakeBroker(t)
+	addr := b.ln.Addr().(*net.TCPAddr)
+	cli, clean, err := NewMQTTClient(MQTTConfig{
+		Host:            addr.IP.String(),
+		Port:            addr.Port,
+		ProtocolVersion: 5,
+		QoS:             1,
+		Expiry:          30,
+		ShareGroup:      "g1",
+		Topics:          map[string]MQTTTopicConfig{"logs": {QoS: 1, UserProperties: []string{"", "project", "level"}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	<-b.connects
+	cb := &lostCallback{lost: make(chan error, 1)}
+	cli.Callback(cb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	received := make(chan []string, 100)
+	if err := cli.Consume(ctx, []string{"data", "#"}, 4, func(topic string, topicSplit []string, payload []byte) {
+		received <- append(topicSplit, string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Consume(ctx, []string{"denied"}, 1, func(string, []string, []byte) {}); !errors.As(err, new(*ReasonCodeError)) {
+		t.Errorf("订阅被拒绝应返回原因码错误,实际为=%v", err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	props := <-b.published
+	if props.MessageExpiry == nil || *props.MessageExpiry != 30 {
+		t.Errorf("消息过期时间应为=30,实际为=%v", props.MessageExpiry)
+	}
+	want := []UserProperty{{"class", "data"}, {"project", "p1"}, {"table", "t1"}, {"device", "d1"}}
+	if len(props.User) != len(want) {
+		t.Fatalf("用户属性应为=%v,实际为=%v", want, props.User)
+	}
+	for i := range want {
+		if props.User[i].Key != want[i].Key || props.User[i].Value != want[i].Value {
+			t.Errorf("用户属性应为=%v,实际为=%v", want[i], props.User[i])
+		}
+	}
+	select {
+	case msg := <-received:
+		if len(msg) != 5 || msg[3] != "d1" || msg[4] != "v" {
+			t.Errorf("收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到订阅的消息")
+	}
+	if err := cli.Publish(ctx, []string{"logs", "p1", "info", "t1", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if props := <-b.published; len(props.User) != 2 || props.User[1] != (packets.User{Key: "level", Value: "info"}) {
+		t.Errorf("日志用户属性错误: %v", props.User)
+	}
+
+	// 消息按接收顺序处理
+	for i := 0; i < 20; i++ {
+		b.send(&packets.Publish{Topic: "data/p1/t1/d1", QoS: 1, PacketID: uint16(i + 1), Properties: &packets.Properties{}, Payload: []byte(fmt.Sprint(i))})
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case msg := <-received:
+			if msg[4] != fmt.Sprint(i) {
+				t.Fatalf("消息顺序错误,应为=%d,实际为=%s", i, msg[4])
+			}
+		case <-ctx.Done():
+			t.Fatal("未收到订阅的消息")
+		}
+	}
+	// 已回复PUBREC的QoS2消息重发时只确认不再处理
+	q2 := &packets.Publish{Topic: "data/p1/t1/d1", QoS: 2, PacketID: 100, Properties: &packets.Properties{}, Payload: []byte("q2")}
+	b.send(q2)
+	<-b.pubrecs
+	q2.Duplicate = true
+	b.send(q2)
+	<-b.pubrecs
+	b.send(&packets.Pubrel{PacketID: 100, Properties: &packets.Properties{}})
+	b.send(&packets.Publish{Topic: "data/p1/t1/d1", Properties: &packets.Properties{}, Payload: []byte("end")})
+	for _, v := range []string{"q2", "end"} {
+		select {
+		case msg := <-received:
+			if msg[4] != v {
+				t.Fatalf("QoS2消息应只处理一次,应为=%s,实际为=%s", v, msg[4])
+			}
+		case <-ctx.Done():
+			t.Fatal("未收到订阅的消息")
+		}
+	}
+
+	subs := b.subCount()
+	b.disconnect(0x8B, "维护")
+	select {
+	case err := <-cb.lost:
+		var reasonErr *ReasonCodeError
+		if !errors.As(err, &reasonErr) || reasonErr.Code != 0x8B || reasonErr.Reason != "维护" {
+			t.Errorf("断开原因应为原因码0x8B,实际为=%v", err)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到断开回调")
+	}
+	// 重连后自动重新订阅
+	for b.subCount() <= subs {
+		select {
+		case <-ctx.Done():
+			t.Fatal("未自动重连并重新订阅")
+		case <-time.After(time.Millisecond * 10):
+		}
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d2"}, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	<-b.published
+	select {
+	case msg := <-received:
+		if msg[4] != "v2" {
+			t.Errorf("重连后收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("重连后未收到订阅的消息")
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if len(b.subs) < 3 || b.subs[0] != "$share/g1/data/#" || b.subs[len(b.subs)-1] != "$share/g1/data/#" {
+		t.Errorf("共享订阅主题错误: %v", b.subs)
+	}
+}
+
+func TestMQTT5_Retained(t *testing.T) {
+	b := newFakeBroker(t)
+	addr := b.ln.Addr().(*net.TCPAddr)
+	cli, clean, err := NewMQTTClient(MQTTConfig{
+		Host:            addr.IP.String(),
+		Port:            addr.Port,
+		ProtocolVersion: 5,
+		QoS:             1,
+		Topics:          map[string]MQTTTopicConfig{"status": {QoS: 1, Retain: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := cli.Publish(ctx, []string{"status", "d1"}, []byte("online")); err != nil {
+		t.Fatal(err)
+	}
+	<-b.published
+	received := make(chan string, 2)
+	if err := cli.Consume(ctx, []string{"status", "+"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		received <- string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-received:
+		if v != "online" {
+			t.Errorf("保留消息应为=online,实际为=%s", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("订阅后未收到保留消息")
+	}
+}
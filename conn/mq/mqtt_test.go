@@ -0,0 +1,166 @@
+package mq
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	packetsv4 "github.com/eclipse/paho.mqtt.golang/packets"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
+)
+
+func TestMQTTConfig_DNS(t *testing.T) {
+	tests := []struct {
+		cfg  MQTTConfig
+		want string
+	}{
+		{MQTTConfig{Host: "localhost", Port: 1883}, "tcp://localhost:1883"},
+		{MQTTConfig{Host: "localhost", Port: 8883, TLS: tlsx.Config{Enable: true}}, "ssl://localhost:8883"},
+		{MQTTConfig{Host: "localhost", Port: 8084, Scheme: "WSS"}, "wss://localhost:8084/mqtt"},
+		{MQTTConfig{Host: "localhost", Port: 8083, Scheme: "ws", Path: "/ws"}, "ws://localhost:8083/ws"},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.DNS(); got != tt.want {
+			t.Errorf("连接地址应为=%s,实际为=%s", tt.want, got)
+		}
+	}
+}
+
+func TestMQTTConfig_TopicConfig(t *testing.T) {
+	cfg := MQTTConfig{QoS: 0, Topics: map[string]MQTTTopicConfig{
+		// 通过配置文件读取时主题类别为小写
+		"warningstorage": {QoS: 1},
+		"data":           {QoS: 0, Retain: true},
+	}}
+	if got := cfg.topicConfig([]string{"warningStorage", "p", "t", "d"}); got.QoS != 1 {
+		t.Errorf("报警消息质量应为=1,实际为=%d", got.QoS)
+	}
+	if got := cfg.topicConfig([]string{"data", "p", "t", "d"}); !got.Retain {
+		t.Error("数据应为保留消息")
+	}
+	if got := cfg.topicConfig([]string{"logs"}); got.QoS != 0 || got.Retain {
+		t.Errorf("日志应使用默认配置,实际为=%+v", got)
+	}
+	if _, _, err := NewMQTTClient(MQTTConfig{ProtocolVersion: 6}); err == nil {
+		t.Error("不支持的协议版本应返回错误")
+	}
+}
+
+// fakeBrokerV4 测试用的MQTT v3.1.1 服务端,只记录订阅并在订阅后断开连接
+type fakeBrokerV4 struct {
+	ln   net.Listener
+	lock sync.Mutex
+	conn net.Conn
+	subs chan string
+}
+
+// listenFakeBroker 监听本地随机端口,每个连接由serve处理,测试结束时关闭
+func listenFakeBroker(t *testing.T, serve func(net.Conn)) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = ln.Close() })
+	return ln
+}
+
+func newFakeBrokerV4(t *testing.T) *fakeBrokerV4 {
+	b := &fakeBrokerV4{subs: make(chan string, 10)}
+	b.ln = listenFakeBroker(t, b.serve)
+	return b
+}
+
+func (b *fakeBrokerV4) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		cp, err := packetsv4.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var resp packetsv4.ControlPacket
+		switch p := cp.(type) {
+		case *packetsv4.ConnectPacket:
+			b.lock.Lock()
+			b.conn = conn
+			b.lock.Unlock()
+			resp = packetsv4.NewControlPacket(packetsv4.Connack)
+		case *packetsv4.SubscribePacket:
+			b.subs <- p.Topics[0]
+			suback := packetsv4.NewControlPacket(packetsv4.Suback).(*packetsv4.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = []byte{0}
+			resp = suback
+		case *packetsv4.UnsubscribePacket:
+			unsuback := packetsv4.NewControlPacket(packetsv4.Unsuback).(*packetsv4.UnsubackPacket)
+			unsuback.MessageID = p.MessageID
+			resp = unsuback
+		case *packetsv4.PingreqPacket:
+			resp = packetsv4.NewControlPacket(packetsv4.Pingresp)
+		case *packetsv4.DisconnectPacket:
+			return
+		}
+		if resp != nil {
+			_ = resp.Write(conn)
+		}
+	}
+}
+
+func (b *fakeBrokerV4) closeConn() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_ = b.conn.Close()
+}
+
+func TestMQTT_Resubscribe(t *testing.T) {
+	b := newFakeBrokerV4(t)
+	addr := b.ln.Addr().(*net.TCPAddr)
+	cli, clean, err := NewMQTTClient(MQTTConfig{Host: addr.IP.String(), Port: addr.Port, KeepAlive: 60, ConnectTimeout: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	ctx := context.Background()
+	handler := func(topic string, topicSplit []string, payload []byte) {}
+	for _, topic := range [][]string{{"data", "#"}, {"cmd", "+"}} {
+		if err := cli.Consume(ctx, topic, 2, handler); err != nil {
+			t.Fatal(err)
+		}
+		<-b.subs
+	}
+	if err := cli.UnSubscription(ctx, []string{"cmd", "+"}); err != nil {
+		t.Fatal(err)
+	}
+	if subs := Subscriptions(cli); len(subs) != 1 || !reflect.DeepEqual(subs[0].Topic, []string{"data", "#"}) {
+		t.Fatalf("订阅记录错误: %+v", subs)
+	}
+
+	// 断开后自动重连并恢复订阅
+	b.closeConn()
+	select {
+	case filter := <-b.subs:
+		if filter != "data/#" {
+			t.Errorf("恢复的订阅应为=data/#,实际为=%s", filter)
+		}
+	case <-time.After(time.Second * 10):
+		t.Fatal("重连后未恢复订阅")
+	}
+	select {
+	case filter := <-b.subs:
+		t.Errorf("已取消的订阅不应恢复: %s", filter)
+	case <-time.After(time.Millisecond * 200):
+	}
+}
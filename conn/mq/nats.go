@@ -0,0 +1,295 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
+)
+
+var _ MQ = new(natsMQ)
+
+const TOPICSEPWITHNATS = "."
+
+// NATSConfig nats配置参数
+type NATSConfig struct {
+	Host          string        `json:"host" yaml:"host"`
+	Port          int           `json:"port" yaml:"port"`
+	Username      string        `json:"username" yaml:"username"`
+	Password      string        `json:"password" yaml:"password"`
+	Token         string        `json:"token" yaml:"token"`
+	TLS           tlsx.Config   `json:"tls" yaml:"tls"`
+	Name          string        `json:"name" yaml:"name"`                   // 客户端名称
+	ReconnectWait time.Duration `json:"reconnectWait" yaml:"reconnectWait"` // 重连间隔,默认2秒
+	MaxReconnects int           `json:"maxReconnects" yaml:"maxReconnects"` // 最大重连次数,默认一直重连
+	// QueueGroup 队列组,不为空时同一队列组的多个实例分摊消息
+	QueueGroup string              `json:"queueGroup" yaml:"queueGroup"`
+	JetStream  NATSJetStreamConfig `json:"jetStream" yaml:"jetStream"`
+}
+
+// NATSJetStreamConfig JetStream配置,启用后发送消息需要服务端确认,订阅在处理完成后确认消息
+type NATSJetStreamConfig struct {
+	Enable bool `json:"enable" yaml:"enable"`
+	// Stream和Subjects 流不存在时按配置创建,为空时使用服务端已有的流
+	Stream   string   `json:"stream" yaml:"stream"`
+	Subjects []string `json:"subjects" yaml:"subjects"`
+	// Durable 持久消费者名称前缀,消费者名为{Durable}_{subject},为空时使用临时消费者
+	Durable    string        `json:"durable" yaml:"durable"`
+	AckWait    time.Duration `json:"ackWait" yaml:"ackWait"`       // 等待确认的时间,超时后重新投递
+	MaxDeliver int           `json:"maxDeliver" yaml:"maxDeliver"` // 最大投递次数
+}
+
+func (a NATSConfig) DNS() string {
+	scheme := "nats"
+	if a.TLS.Enable {
+		scheme = "tls"
+	}
+	return fmt.Sprintf("%s://%s:%d", scheme, a.Host, a.Port)
+}
+
+type natsMQ struct {
+	lostError
+	// subscriptionRegistry 订阅记录,重连后由nats客户端自动恢复订阅
+	subscriptionRegistry[*nats.Subscription]
+	lock      sync.RWMutex
+	cfg       NATSConfig
+	conn      *nats.Conn
+	js        nats.JetStreamContext
+	callbacks []Callback
+}
+
+// natsSubject topic转换为nats主题,MQTT通配符+和#分别转换为*和>
+func natsSubject(topicParams []string) string {
+	params := make([]string, len(topicParams))
+	for i, p := range topicParams {
+		switch p {
+		case "+":
+			params[i] = "*"
+		case "#":
+			params[i] = ">"
+		default:
+			params[i] = p
+		}
+	}
+	return strings.Join(params, TOPICSEPWITHNATS)
+}
+
+var durableReplacer = strings.NewReplacer(".", "_", "*", "+", ">", "#")
+
+// durableName 持久消费者名称,名称中不能包含.*>
+func (a NATSJetStreamConfig) durableName(subject string) string {
+	if a.Durable == "" {
+		return ""
+	}
+	return a.Durable + "_" + durableReplacer.Replace(subject)
+}
+
+// NewNATSClient 创建NATS消息队列
+func NewNATSClient(cfg NATSConfig) (MQ, func(), error) {
+	if cfg.ReconnectWait <= 0 {
+		cfg.ReconnectWait = time.Second * 2
+	}
+	if cfg.MaxReconnects == 0 {
+		cfg.MaxReconnects = -1
+	}
+	if cfg.JetStream.Stream != "" && len(cfg.JetStream.Subjects) == 0 {
+		return nil, nil, fmt.Errorf("JetStream流 %s 的主题未配置", cfg.JetStream.Stream)
+	}
+	tlsCfg, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	cli := &natsMQ{cfg: cfg, callbacks: make([]Callback, 0)}
+	opts := []nats.Option{
+		nats.ReconnectWait(cfg.ReconnectWait),
+		nats.MaxReconnects(cfg.MaxReconnects),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err == nil {
+				return
+			}
+			logger.Errorf("NATS Lost错误: %s", err.Error())
+			cli.lostError.set(err)
+			cli.lost()
+		}),
+		nats.ReconnectHandler(func(_ *nats.Conn) {
+			logger.Infof("NATS 已重连")
+			cli.connect()
+		}),
+	}
+	if cfg.Name != "" {
+		opts = append(opts, nats.Name(cfg.Name))
+	}
+	if cfg.Username != "" {
+		opts = append(opts, nats.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, nats.Token(cfg.Token))
+	}
+	if tlsCfg != nil {
+		opts = append(opts, nats.Secure(tlsCfg))
+	}
+	conn, err := nats.Connect(cfg.DNS(), opts...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建NATS客户端错误: %w", err)
+	}
+	cli.conn = conn
+	if cfg.JetStream.Enable {
+		if err := cli.initJetStream(); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	// 直接关闭连接,退订会删除JetStream持久消费者
+	cleanFunc := func() {
+		conn.Close()
+	}
+	return cli, cleanFunc, nil
+}
+
+func (p *natsMQ) initJetStream() error {
+	js, err := p.conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("创建JetStream错误: %w", err)
+	}
+	p.js = js
+	stream := p.cfg.JetStream.Stream
+	if stream == "" {
+		return nil
+	}
+	if _, err := js.StreamInfo(stream); err == nil {
+		return nil
+	} else if !errors.Is(err, nats.ErrStreamNotFound) {
+		return fmt.Errorf("查询JetStream流 %s 错误: %w", stream, err)
+	}
+	if _, err := js.AddStream(&nats.StreamConfig{Name: stream, Subjects: p.cfg.JetStream.Subjects}); err != nil {
+		return fmt.Errorf("创建JetStream流 %s 错误: %w", stream, err)
+	}
+	return nil
+}
+
+func (p *natsMQ) Callback(cb Callback) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.callbacks = append(p.callbacks, cb)
+}
+
+func (p *natsMQ) lost() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Lost(p); err != nil {
+			logger.Fatalf("lost callback err, %s", err)
+		}
+	}
+}
+
+func (p *natsMQ) connect() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Connect(p); err != nil {
+			logger.Fatalf("connect callback err, %s", err)
+		}
+	}
+}
+
+func (p *natsMQ) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return p.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+// HeadersSupported 服务端支持消息头时支持元数据
+func (p *natsMQ) HeadersSupported() bool {
+	return p.conn.HeadersSupported()
+}
+
+// PublishWithHeader 发送消息,元数据使用消息头,服务端不支持消息头时只发送消息内容
+func (p *natsMQ) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
+	msg := nats.NewMsg(natsSubject(topicParams))
+	msg.Data = payload
+	if len(header) > 0 && p.conn.HeadersSupported() {
+		for k, v := range header {
+			msg.Header.Set(k, v)
+		}
+	}
+	if p.js != nil {
+		if _, err := p.js.PublishMsg(msg, nats.Context(ctx)); err != nil {
+			return fmt.Errorf("发送消息错误: %w", err)
+		}
+		return nil
+	}
+	return p.conn.PublishMsg(msg)
+}
+
+func (p *natsMQ) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
+	subject := natsSubject(topicParams)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.get(topicParams); ok {
+		return fmt.Errorf("主题 %s 已订阅", subject)
+	}
+	cb := func(msg *nats.Msg) {
+		p.handle(msg, splitN, handler)
+	}
+	var sub *nats.Subscription
+	var err error
+	if p.js != nil {
+		jsCfg := p.cfg.JetStream
+		opts := []nats.SubOpt{nats.ManualAck()}
+		if durable := jsCfg.durableName(subject); durable != "" {
+			opts = append(opts, nats.Durable(durable))
+		}
+		if jsCfg.AckWait > 0 {
+			opts = append(opts, nats.AckWait(jsCfg.AckWait))
+		}
+		if jsCfg.MaxDeliver > 0 {
+			opts = append(opts, nats.MaxDeliver(jsCfg.MaxDeliver))
+		}
+		if p.cfg.QueueGroup != "" {
+			sub, err = p.js.QueueSubscribe(subject, p.cfg.QueueGroup, cb, opts...)
+		} else {
+			sub, err = p.js.Subscribe(subject, cb, opts...)
+		}
+	} else if p.cfg.QueueGroup != "" {
+		sub, err = p.conn.QueueSubscribe(subject, p.cfg.QueueGroup, cb)
+	} else {
+		sub, err = p.conn.Subscribe(subject, cb)
+	}
+	if err != nil {
+		return err
+	}
+	p.add(topicParams, splitN, sub)
+	return nil
+}
+
+// handle 处理消息,JetStream消息处理完成后确认,处理异常时不确认等待重新投递
+func (p *natsMQ) handle(msg *nats.Msg, splitN int, handler Handler) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("NATS 消息处理异常: 主题=%s. %v", msg.Subject, r)
+		}
+	}()
+	if payload, ok := decompressPayload(msg.Subject, msg.Header.Get(HeaderContentEncoding), msg.Data); ok {
+		handler(msg.Subject, strings.SplitN(msg.Subject, TOPICSEPWITHNATS, splitN), payload)
+	}
+	if p.js != nil {
+		if err := msg.Ack(); err != nil {
+			logger.Errorf("NATS 确认消息错误: 主题=%s. %s", msg.Subject, err.Error())
+		}
+	}
+}
+
+func (p *natsMQ) UnSubscription(ctx context.Context, topicParams []string) error {
+	sub, ok := p.remove(topicParams)
+	if !ok {
+		return nil
+	}
+	return sub.Unsubscribe()
+}
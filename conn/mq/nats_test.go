@@ -0,0 +1,220 @@
+package mq
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats-server/v2/server"
+)
+
+// runNATSServer 启动进程内的NATS服务端,port为server.RANDOM_PORT时随机端口,storeDir不为空时启用JetStream
+func runNATSServer(t *testing.T, port int, storeDir string) *server.Server {
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      port,
+		NoLog:     true,
+		NoSigs:    true,
+		JetStream: storeDir != "",
+		StoreDir:  storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	if !s.ReadyForConnections(time.Second * 5) {
+		t.Fatal("NATS服务端启动超时")
+	}
+	t.Cleanup(s.Shutdown)
+	return s
+}
+
+func natsServerConfig(s *server.Server) NATSConfig {
+	addr := s.Addr().(*net.TCPAddr)
+	return NATSConfig{Host: addr.IP.String(), Port: addr.Port, ReconnectWait: time.Millisecond * 50}
+}
+
+type connCallback struct {
+	connect chan struct{}
+	lost    chan error
+}
+
+func (c *connCallback) Connect(MQ) error {
+	c.connect <- struct{}{}
+	return nil
+}
+
+func (c *connCallback) Lost(m MQ) error {
+	c.lost <- LostErr(m)
+	return nil
+}
+
+func TestNATSSubject(t *testing.T) {
+	if got := natsSubject([]string{"data", "p1", "+", "#"}); got != "data.p1.*.>" {
+		t.Errorf("主题应为=data.p1.*.>,实际为=%s", got)
+	}
+	cfg := NATSJetStreamConfig{Durable: "driver"}
+	if got := cfg.durableName("data.p1.*.>"); got != "driver_data_p1_+_#" {
+		t.Errorf("持久消费者名称应为=driver_data_p1_+_#,实际为=%s", got)
+	}
+}
+
+func TestNATS(t *testing.T) {
+	s := runNATSServer(t, server.RANDOM_PORT, "")
+	cfg := natsServerConfig(s)
+	cli, clean, err := NewNATSClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	cb := &connCallback{connect: make(chan struct{}, 1), lost: make(chan error, 1)}
+	cli.Callback(cb)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	received := make(chan []string, 10)
+	if err := cli.Consume(ctx, []string{"data", "p1", "+", "#"}, 4, func(topic string, topicSplit []string, payload []byte) {
+		received <- append(topicSplit, string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 等待订阅到达服务端
+	if err := cli.(*natsMQ).conn.FlushWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if len(msg) != 5 || msg[2] != "t1" || msg[3] != "d1" || msg[4] != "v" {
+			t.Errorf("收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到订阅的消息")
+	}
+
+	// 重启服务端
+	s.Shutdown()
+	runNATSServer(t, cfg.Port, "")
+	select {
+	case err := <-cb.lost:
+		if err == nil {
+			t.Error("断开原因不应为空")
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到断开回调")
+	}
+	select {
+	case <-cb.connect:
+	case <-ctx.Done():
+		t.Fatal("未收到重连回调")
+	}
+	// 重连后订阅自动恢复
+	if err := cli.(*natsMQ).conn.FlushWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d2"}, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg[4] != "v2" {
+			t.Errorf("重连后收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("重连后未收到订阅的消息")
+	}
+
+	if err := cli.UnSubscription(ctx, []string{"data", "p1", "+", "#"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.(*natsMQ).conn.FlushWithContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d3"}, []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	_ = cli.(*natsMQ).conn.FlushWithContext(ctx)
+	select {
+	case msg := <-received:
+		t.Errorf("取消订阅后不应收到消息: %v", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+}
+
+func TestNATS_JetStream(t *testing.T) {
+	dir := t.TempDir()
+	s := runNATSServer(t, server.RANDOM_PORT, dir)
+	cfg := natsServerConfig(s)
+	cfg.JetStream = NATSJetStreamConfig{Enable: true, Stream: "DATA", Subjects: []string{"data.>"}, Durable: "driver", AckWait: time.Millisecond * 200}
+	cli, clean, err := NewNATSClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	// 处理异常时不确认,等待AckWait后重新投递
+	var deliveries atomic.Int32
+	received := make(chan string, 10)
+	if err := cli.Consume(ctx, []string{"data", "#"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		if deliveries.Add(1) == 1 {
+			panic("处理异常")
+		}
+		received <- string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "d1"}, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-received:
+		if v != "v1" {
+			t.Fatalf("重新投递的消息错误: %s", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("处理异常后未重新投递")
+	}
+	// 已确认的消息不再投递
+	select {
+	case v := <-received:
+		t.Fatalf("已确认的消息不应再投递: %s", v)
+	case <-time.After(time.Millisecond * 500):
+	}
+	if n := deliveries.Load(); n != 2 {
+		t.Fatalf("投递次数应为=2,实际为=%d", n)
+	}
+	clean()
+
+	// 持久消费者断开期间的消息在重新订阅后投递
+	pub, pubClean, err := NewNATSClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pubClean()
+	if err := pub.Publish(ctx, []string{"data", "d2"}, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	cli, clean, err = NewNATSClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	if err := cli.Consume(ctx, []string{"data", "#"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		received <- topic + ":" + string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case v := <-received:
+		if v != "data.d2:v2" {
+			t.Fatalf("持久消费者收到的消息错误: %s", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("持久消费者未收到断开期间的消息")
+	}
+}
@@ -5,27 +5,57 @@ import (
 	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/rabbitmq/amqp091-go"
 
 	"github.com/air-iot/logger"
 )
 
+var _ MQ = new(rabbit)
+
+var errRabbitNotConnected = errors.New("RabbitMQ未连接")
+
 type rabbit struct {
-	//queue    string
-	//exchange string
-	conn *amqp091.Connection
+	lostError
+	subscriptionRegistry[*rabbitSub]
+	cfg       RabbitMQConfig
+	lock      sync.RWMutex
+	conn      *amqp091.Connection
+	channels  chan *amqp091.Channel
+	callbacks []Callback
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// rabbitSub 订阅信息,重连后重新声明队列和绑定
+type rabbitSub struct {
+	topic     string
+	splitN    int
+	handler   Handler
+	manualAck bool
+	channel   *amqp091.Channel
 }
 
 // RabbitMQConfig rabbitmq配置参数
 type RabbitMQConfig struct {
-	Host     string `json:"host" yaml:"host"`
-	Port     int    `json:"port" yaml:"port"`
-	Username string `json:"username" yaml:"username"`
-	Password string `json:"password" yaml:"password"`
-	VHost    string `json:"vHost" yaml:"vHost"`
-	Exchange string `json:"exchange" yaml:"exchange"`
-	Queue    string `json:"queue" yaml:"queue"`
+	Host         string `json:"host" yaml:"host"`
+	Port         int    `json:"port" yaml:"port"`
+	Username     string `json:"username" yaml:"username"`
+	Password     string `json:"password" yaml:"password"`
+	VHost        string `json:"vHost" yaml:"vHost"`
+	Exchange     string `json:"exchange" yaml:"exchange"`
+	ExchangeType string `json:"exchangeType" yaml:"exchangeType"` // 交换机类型,默认topic
+	// Queue 订阅队列名称前缀,队列名为{Queue}.{topic},同名队列的多个实例分摊消息.为空时每次订阅使用独占的临时队列
+	Queue       string `json:"queue" yaml:"queue"`
+	ChannelPool int    `json:"channelPool" yaml:"channelPool"` // 发送消息的通道池大小,默认8
+	Confirm     bool   `json:"confirm" yaml:"confirm"`         // 发送消息等待服务端确认
+	Persistent  bool   `json:"persistent" yaml:"persistent"`   // 消息持久化
+	// ManualAck 消息处理完成后再确认,处理异常时拒绝消息,默认收到即确认.ConsumeWithRetry的订阅始终手动确认
+	ManualAck         bool          `json:"manualAck" yaml:"manualAck"`
+	Prefetch          int           `json:"prefetch" yaml:"prefetch"`                   // 未确认消息的最大数量,默认1
+	ReconnectInterval time.Duration `json:"reconnectInterval" yaml:"reconnectInterval"` // 重连间隔,默认5秒
 }
 
 func (a RabbitMQConfig) DNS() string {
@@ -37,33 +67,184 @@ func (a RabbitMQConfig) DNS() string {
 		a.VHost)
 }
 
+// queueName 订阅主题使用的队列名称,为空时由服务端生成
+func (a RabbitMQConfig) queueName(topic string) string {
+	if a.Queue == "" {
+		return ""
+	}
+	return a.Queue + TOPICSEPWITHRABBIT + topic
+}
+
 const TOPICSEPWITHRABBIT = "."
 
+// NewRabbitClient 创建RabbitMQ消息队列
 func NewRabbitClient(cfg RabbitMQConfig) (MQ, func(), error) {
-	conn, err := amqp091.Dial(cfg.DNS())
-	if err != nil {
-		return nil, nil, fmt.Errorf("创建AMQP客户端错误: %+v", err)
+	if cfg.Exchange == "" {
+		return nil, nil, errors.New("RabbitMQ交换机未配置")
 	}
-	m := new(rabbit)
-	m.conn = conn
+	if cfg.ExchangeType == "" {
+		cfg.ExchangeType = amqp091.ExchangeTopic
+	}
+	if cfg.ChannelPool <= 0 {
+		cfg.ChannelPool = 8
+	}
+	if cfg.Prefetch <= 0 {
+		cfg.Prefetch = 1
+	}
+	if cfg.ReconnectInterval <= 0 {
+		cfg.ReconnectInterval = time.Second * 5
+	}
+	m := &rabbit{
+		cfg:       cfg,
+		callbacks: make([]Callback, 0),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := m.dial(); err != nil {
+		return nil, nil, err
+	}
+	go m.watch()
+	var once sync.Once
 	cleanFunc := func() {
-		if err := conn.Close(); err != nil {
-			logger.Errorf("rabbitmq close error: %s", err.Error())
-		}
+		once.Do(func() {
+			close(m.stop)
+			m.lock.Lock()
+			conn := m.conn
+			m.conn = nil
+			m.lock.Unlock()
+			if conn != nil {
+				if err := conn.Close(); err != nil {
+					logger.Errorf("rabbitmq close error: %s", err.Error())
+				}
+			}
+			<-m.done
+		})
 	}
 	return m, cleanFunc, nil
 }
 
-func (p *rabbit) Callback(Callback) {}
+// dial 建立连接并声明交换机
+func (p *rabbit) dial() error {
+	conn, err := amqp091.Dial(p.cfg.DNS())
+	if err != nil {
+		return fmt.Errorf("创建AMQP客户端错误: %+v", err)
+	}
+	channel, err := conn.Channel()
+	if err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("创建AMQP通道错误: %w", err)
+	}
+	defer channel.Close()
+	if err := p.NewExchange(channel, p.cfg.Exchange); err != nil {
+		_ = conn.Close()
+		return fmt.Errorf("声明交换机错误: %w", err)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-p.stop:
+		_ = conn.Close()
+		return errRabbitNotConnected
+	default:
+	}
+	p.conn = conn
+	p.channels = make(chan *amqp091.Channel, p.cfg.ChannelPool)
+	return nil
+}
 
+// watch 连接断开后重连,并重新声明订阅的队列和绑定
+func (p *rabbit) watch() {
+	defer close(p.done)
+	for {
+		p.lock.RLock()
+		conn := p.conn
+		p.lock.RUnlock()
+		if conn == nil {
+			return
+		}
+		closed := conn.NotifyClose(make(chan *amqp091.Error, 1))
+		var err error
+		select {
+		case <-p.stop:
+			return
+		case amqpErr, ok := <-closed:
+			if !ok || amqpErr == nil {
+				err = errRabbitNotConnected
+			} else {
+				err = amqpErr
+			}
+		}
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+		logger.Errorf("RabbitMQ Lost错误: %s", err.Error())
+		p.lostError.set(err)
+		p.lost()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-time.After(p.cfg.ReconnectInterval):
+			}
+			if err := p.dial(); err != nil {
+				logger.Errorf("RabbitMQ 重连错误: %s", err.Error())
+				continue
+			}
+			break
+		}
+		logger.Infof("RabbitMQ 已重连")
+		p.resubscribe()
+		p.connect()
+	}
+}
+
+func (p *rabbit) resubscribe() {
+	for _, sub := range p.list() {
+		if err := p.subscribe(sub); err != nil {
+			logger.Errorf("RabbitMQ 重新订阅错误: 主题=%s. %s", sub.topic, err.Error())
+		}
+	}
+}
+
+func (p *rabbit) Callback(cb Callback) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.callbacks = append(p.callbacks, cb)
+}
+
+func (p *rabbit) lost() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Lost(p); err != nil {
+			logger.Fatalf("lost callback err, %s", err)
+		}
+	}
+}
+
+func (p *rabbit) connect() {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Connect(p); err != nil {
+			logger.Fatalf("connect callback err, %s", err)
+		}
+	}
+}
+
+// NewQueue 声明队列.命名队列持久化且不自动删除,断线重连或实例重启期间消息保留在队列中;
+// 名称为空时由服务端生成独占的临时队列,连接断开后自动删除
 func (p *rabbit) NewQueue(channel *amqp091.Channel, queueName string) (*amqp091.Queue, error) {
+	temporary := queueName == ""
 	queue, err := channel.QueueDeclare(
-		queueName, // name
-		true,      // durable
-		true,      // delete when unused
-		false,     // exclusive
-		false,     // no-wait
-		nil,       // arguments
+		queueName,  // name
+		!temporary, // durable
+		temporary,  // delete when unused
+		temporary,  // exclusive
+		false,      // no-wait
+		nil,        // arguments
 	)
 	if err != nil {
 		return nil, err
@@ -73,106 +254,248 @@ func (p *rabbit) NewQueue(channel *amqp091.Channel, queueName string) (*amqp091.
 
 func (p *rabbit) NewExchange(channel *amqp091.Channel, exchange string) error {
 	return channel.ExchangeDeclare(
-		exchange, // name
-		"topic",  // type
-		true,     // durable
-		false,    // auto-deleted
-		false,    // internal
-		false,    // no-wait
-		nil,      // arguments
+		exchange,           // name
+		p.cfg.ExchangeType, // type
+		true,               // durable
+		false,              // auto-deleted
+		false,              // internal
+		false,              // no-wait
+		nil,                // arguments
 	)
 }
 
+// getChannel 从通道池获取发送消息的通道,池中没有时新建
+func (p *rabbit) getChannel() (*amqp091.Channel, chan *amqp091.Channel, error) {
+	p.lock.RLock()
+	conn, channels := p.conn, p.channels
+	p.lock.RUnlock()
+	if conn == nil || conn.IsClosed() {
+		return nil, nil, errRabbitNotConnected
+	}
+	for {
+		select {
+		case channel := <-channels:
+			if channel.IsClosed() {
+				continue
+			}
+			return channel, channels, nil
+		default:
+		}
+		channel, err := conn.Channel()
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.cfg.Confirm {
+			if err := channel.Confirm(false); err != nil {
+				_ = channel.Close()
+				return nil, nil, err
+			}
+		}
+		return channel, channels, nil
+	}
+}
+
+// putChannel 发送完成后放回通道池,池已满或通道已关闭时关闭通道
+func (p *rabbit) putChannel(channel *amqp091.Channel, channels chan *amqp091.Channel) {
+	if channel.IsClosed() {
+		return
+	}
+	select {
+	case channels <- channel:
+	default:
+		_ = channel.Close()
+	}
+}
+
 func (p *rabbit) Publish(ctx context.Context, topicParams []string, payload []byte) error {
-	channel, err := p.conn.Channel()
+	return p.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+// PublishWithHeader 发送消息,content-type和content-encoding使用消息的ContentType和ContentEncoding属性,其他元数据使用消息头
+func (p *rabbit) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
+	channel, channels, err := p.getChannel()
 	if err != nil {
 		return err
 	}
-	exchange, ok := ctx.Value("exchange").(string)
-	if !ok {
-		return errors.New("context exchange not found")
+	msg := amqp091.Publishing{
+		DeliveryMode: amqp091.Transient,
+		ContentType:  "text/plain",
+		Body:         payload,
+	}
+	if p.cfg.Persistent {
+		msg.DeliveryMode = amqp091.Persistent
+	}
+	for k, v := range header {
+		switch k {
+		case HeaderContentType:
+			msg.ContentType = v
+			continue
+		case HeaderContentEncoding:
+			msg.ContentEncoding = v
+			continue
+		}
+		if msg.Headers == nil {
+			msg.Headers = amqp091.Table{}
+		}
+		msg.Headers[k] = v
 	}
 	topic := strings.Join(topicParams, TOPICSEPWITHRABBIT)
-	return channel.Publish(
-		exchange, // exchange
-		topic,    // routing key
-		false,    // mandatory
+	confirm, err := channel.PublishWithDeferredConfirmWithContext(ctx,
+		p.cfg.Exchange, // exchange
+		topic,          // routing key
+		false,          // mandatory
 		false,
-		amqp091.Publishing{
-			DeliveryMode: amqp091.Transient,
-			ContentType:  "text/plain",
-			Body:         payload,
-		})
-}
-
-func (p *rabbit) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
-	channel, err := p.conn.Channel()
+		msg)
 	if err != nil {
+		_ = channel.Close()
 		return err
 	}
+	if confirm != nil {
+		ack, err := confirm.WaitContext(ctx)
+		if err != nil {
+			// 未收到确认的通道后续确认序号不可用,不再放回通道池
+			_ = channel.Close()
+			return err
+		}
+		if !ack {
+			p.putChannel(channel, channels)
+			return fmt.Errorf("RabbitMQ服务端拒绝消息: %s", topic)
+		}
+	}
+	p.putChannel(channel, channels)
+	return nil
+}
 
-	queue, ok := ctx.Value("exchange").(string)
-	if !ok {
-		return errors.New("context queue not found")
+func (p *rabbit) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
+	return p.addSub(topicParams, &rabbitSub{topic: strings.Join(topicParams, TOPICSEPWITHRABBIT), splitN: splitN, handler: handler, manualAck: p.cfg.ManualAck})
+}
+
+// ConsumeWithRetry 重试订阅始终手动确认,重试和发送死信完成后才确认消息
+func (p *rabbit) ConsumeWithRetry(_ context.Context, topicParams []string, splitN int, cfg RetryConfig, handler ErrorHandler) error {
+	return p.addSub(topicParams, &rabbitSub{topic: strings.Join(topicParams, TOPICSEPWITHRABBIT), splitN: splitN, handler: newRetryHandler(p, cfg, handler), manualAck: true})
+}
+
+func (p *rabbit) addSub(topicParams []string, sub *rabbitSub) error {
+	if _, ok := p.get(topicParams); ok {
+		return fmt.Errorf("主题 %s 已订阅", sub.topic)
 	}
+	if err := p.subscribe(sub); err != nil {
+		return err
+	}
+	p.add(topicParams, sub.splitN, sub)
+	return nil
+}
 
-	exchange, ok := ctx.Value("exchange").(string)
-	if !ok {
-		return errors.New("context exchange not found")
+// subscribe 声明队列、绑定主题并开始消费
+func (p *rabbit) subscribe(sub *rabbitSub) error {
+	p.lock.RLock()
+	conn := p.conn
+	p.lock.RUnlock()
+	if conn == nil {
+		return errRabbitNotConnected
 	}
-	q, err := p.NewQueue(channel, queue)
+	channel, err := conn.Channel()
 	if err != nil {
 		return err
 	}
-	err = p.NewExchange(channel, exchange)
+	messages, err := p.consume(channel, sub)
 	if err != nil {
+		_ = channel.Close()
 		return err
 	}
-	topic := strings.Join(topicParams, TOPICSEPWITHRABBIT)
+	p.lock.Lock()
+	sub.channel = channel
+	p.lock.Unlock()
+	go func() {
+		for d := range messages {
+			p.handle(sub, d)
+		}
+	}()
+	return nil
+}
+
+func (p *rabbit) consume(channel *amqp091.Channel, sub *rabbitSub) (<-chan amqp091.Delivery, error) {
+	topic := sub.topic
+	q, err := p.NewQueue(channel, p.cfg.queueName(topic))
+	if err != nil {
+		return nil, err
+	}
 	err = channel.QueueBind(
-		q.Name,   // queue name
-		topic,    // routing key
-		exchange, // exchange
+		q.Name,         // queue name
+		topic,          // routing key
+		p.cfg.Exchange, // exchange
 		false,
 		nil,
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = channel.Qos(
-		1,     // prefetch count
-		0,     // prefetch size
-		false, // global
+		p.cfg.Prefetch, // prefetch count
+		0,              // prefetch size
+		false,          // global
 	)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	messages, err := channel.Consume(
-		q.Name, // queue
-		topic,  // consumer
-		true,   // auto-ack
-		false,  // exclusive
-		false,  // no-local
-		false,  // no-wait
-		nil,    // args
+	return channel.Consume(
+		q.Name,         // queue
+		topic,          // consumer
+		!sub.manualAck, // auto-ack
+		false,          // exclusive
+		false,          // no-local
+		false,          // no-wait
+		nil,            // args
 	)
-	if err != nil {
-		return err
-	}
-	go func() {
-		for d := range messages {
-			handler(d.RoutingKey, strings.SplitN(d.RoutingKey, TOPICSEPWITHRABBIT, splitN), d.Body)
-			//if err := d.Ack(false); err != nil {}
+}
+
+// handle 处理消息,手动确认时处理完成后确认,处理异常时拒绝
+func (p *rabbit) handle(sub *rabbitSub, d amqp091.Delivery) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("RabbitMQ 消息处理异常: 主题=%s. %v", d.RoutingKey, r)
+			if sub.manualAck {
+				if err := d.Nack(false, false); err != nil {
+					logger.Errorf("RabbitMQ 拒绝消息错误: 主题=%s. %s", d.RoutingKey, err.Error())
+				}
+			}
 		}
 	}()
-	return nil
+	payload, ok := decompressPayload(d.RoutingKey, d.ContentEncoding, d.Body)
+	if !ok {
+		// 无法解压的消息重新投递也无法处理,直接丢弃
+		if sub.manualAck {
+			if err := d.Nack(false, false); err != nil {
+				logger.Errorf("RabbitMQ 拒绝消息错误: 主题=%s. %s", d.RoutingKey, err.Error())
+			}
+		}
+		return
+	}
+	sub.handler(d.RoutingKey, strings.SplitN(d.RoutingKey, TOPICSEPWITHRABBIT, sub.splitN), payload)
+	if sub.manualAck {
+		if err := d.Ack(false); err != nil {
+			logger.Errorf("RabbitMQ 确认消息错误: 主题=%s. %s", d.RoutingKey, err.Error())
+		}
+	}
 }
 
 func (p *rabbit) UnSubscription(ctx context.Context, topicParams []string) error {
 	topic := strings.Join(topicParams, TOPICSEPWITHRABBIT)
-	channel, err := p.conn.Channel()
-	if err != nil {
+	sub, ok := p.remove(topicParams)
+	p.lock.Lock()
+	var channel *amqp091.Channel
+	if ok {
+		channel = sub.channel
+	}
+	p.lock.Unlock()
+	if channel == nil {
+		return nil
+	}
+	if err := channel.Cancel(topic, false); err != nil && !channel.IsClosed() {
 		return err
 	}
-	return channel.Cancel(topic, true)
+	if err := channel.Close(); err != nil && !errors.Is(err, amqp091.ErrClosed) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,269 @@
+package mq
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRabbitMQConfig_QueueName(t *testing.T) {
+	cfg := RabbitMQConfig{}
+	if got := cfg.queueName("data.p.t.d"); got != "" {
+		t.Errorf("未配置队列前缀时应使用临时队列,实际为=%s", got)
+	}
+	cfg.Queue = "driver"
+	if got := cfg.queueName("data.p.t.d"); got != "driver.data.p.t.d" {
+		t.Errorf("队列名称应为=driver.data.p.t.d,实际为=%s", got)
+	}
+	if _, _, err := NewRabbitClient(RabbitMQConfig{}); err == nil {
+		t.Error("未配置交换机应返回错误")
+	}
+}
+
+// fakeRabbit 测试用的AMQP 0-9-1 服务端,只实现客户端用到的方法
+type fakeRabbit struct {
+	ln       net.Listener
+	lock     sync.Mutex
+	conns    []net.Conn
+	queues   chan rabbitQueue
+	consumes chan rabbitConsume
+}
+
+type rabbitQueue struct {
+	name                           string
+	durable, exclusive, autoDelete bool
+}
+
+type rabbitConsume struct {
+	conn    net.Conn
+	channel uint16
+	tag     string
+}
+
+func newFakeRabbit(t *testing.T) *fakeRabbit {
+	b := &fakeRabbit{queues: make(chan rabbitQueue, 10), consumes: make(chan rabbitConsume, 10)}
+	b.ln = listenFakeBroker(t, b.serve)
+	return b
+}
+
+// amqpArgs 方法参数编码
+type amqpArgs struct {
+	bytes.Buffer
+}
+
+func (a *amqpArgs) short(v uint16) *amqpArgs {
+	_ = binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *amqpArgs) long(v uint32) *amqpArgs {
+	_ = binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *amqpArgs) longlong(v uint64) *amqpArgs {
+	_ = binary.Write(a, binary.BigEndian, v)
+	return a
+}
+
+func (a *amqpArgs) octet(v byte) *amqpArgs {
+	a.WriteByte(v)
+	return a
+}
+
+func (a *amqpArgs) shortstr(s string) *amqpArgs {
+	a.WriteByte(byte(len(s)))
+	a.WriteString(s)
+	return a
+}
+
+func (a *amqpArgs) longstr(s string) *amqpArgs {
+	a.long(uint32(len(s)))
+	a.WriteString(s)
+	return a
+}
+
+// readShortstr 读取短字符串参数,返回字符串和剩余参数
+func readShortstr(b []byte) (string, []byte) {
+	n := int(b[0])
+	return string(b[1 : 1+n]), b[1+n:]
+}
+
+func (b *fakeRabbit) write(conn net.Conn, typ byte, channel uint16, payload []byte) {
+	frame := new(amqpArgs).octet(typ).short(channel).long(uint32(len(payload)))
+	frame.Write(payload)
+	frame.WriteByte(0xCE)
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	_, _ = conn.Write(frame.Bytes())
+}
+
+func (b *fakeRabbit) method(conn net.Conn, channel, class, method uint16, args *amqpArgs) {
+	payload := new(amqpArgs).short(class).short(method)
+	if args != nil {
+		payload.Write(args.Bytes())
+	}
+	b.write(conn, 1, channel, payload.Bytes())
+}
+
+func (b *fakeRabbit) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	if _, err := io.ReadFull(r, make([]byte, 8)); err != nil {
+		return
+	}
+	b.lock.Lock()
+	b.conns = append(b.conns, conn)
+	b.lock.Unlock()
+	b.method(conn, 0, 10, 10, new(amqpArgs).octet(0).octet(9).long(0).longstr("PLAIN").longstr("en_US"))
+	for {
+		header := make([]byte, 7)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return
+		}
+		channel := binary.BigEndian.Uint16(header[1:3])
+		payload := make([]byte, binary.BigEndian.Uint32(header[3:7])+1)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return
+		}
+		if header[0] != 1 {
+			continue
+		}
+		class, method, args := binary.BigEndian.Uint16(payload[0:2]), binary.BigEndian.Uint16(payload[2:4]), payload[4:]
+		switch class<<8 | method {
+		case 10<<8 | 11: // connection.start-ok
+			b.method(conn, 0, 10, 30, new(amqpArgs).short(2047).long(131072).short(0))
+		case 10<<8 | 40: // connection.open
+			b.method(conn, 0, 10, 41, new(amqpArgs).shortstr(""))
+		case 10<<8 | 50: // connection.close
+			b.method(conn, 0, 10, 51, nil)
+			return
+		case 20<<8 | 10: // channel.open
+			b.method(conn, channel, 20, 11, new(amqpArgs).longstr(""))
+		case 20<<8 | 40: // channel.close
+			b.method(conn, channel, 20, 41, nil)
+		case 40<<8 | 10: // exchange.declare
+			b.method(conn, channel, 40, 11, nil)
+		case 50<<8 | 10: // queue.declare
+			name, rest := readShortstr(args[2:])
+			q := rabbitQueue{name: name, durable: rest[0]&2 != 0, exclusive: rest[0]&4 != 0, autoDelete: rest[0]&8 != 0}
+			b.queues <- q
+			if name == "" {
+				name = "amq.gen-1"
+			}
+			b.method(conn, channel, 50, 11, new(amqpArgs).shortstr(name).long(0).long(0))
+		case 50<<8 | 20: // queue.bind
+			b.method(conn, channel, 50, 21, nil)
+		case 60<<8 | 10: // basic.qos
+			b.method(conn, channel, 60, 11, nil)
+		case 60<<8 | 20: // basic.consume
+			_, rest := readShortstr(args[2:])
+			tag, _ := readShortstr(rest)
+			b.method(conn, channel, 60, 21, new(amqpArgs).shortstr(tag))
+			b.consumes <- rabbitConsume{conn: conn, channel: channel, tag: tag}
+		}
+	}
+}
+
+// deliver 向订阅的通道投递消息
+func (b *fakeRabbit) deliver(c rabbitConsume, routingKey string, body []byte) {
+	b.method(c.conn, c.channel, 60, 60, new(amqpArgs).shortstr(c.tag).longlong(1).octet(0).shortstr("test").shortstr(routingKey))
+	b.write(c.conn, 2, c.channel, new(amqpArgs).short(60).short(0).longlong(uint64(len(body))).short(0).Bytes())
+	b.write(c.conn, 3, c.channel, body)
+}
+
+// drop 服务端断开全部连接
+func (b *fakeRabbit) drop() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, conn := range b.conns {
+		_ = conn.Close()
+	}
+	b.conns = nil
+}
+
+func TestRabbit_Resubscribe(t *testing.T) {
+	b := newFakeRabbit(t)
+	addr := b.ln.Addr().(*net.TCPAddr)
+	cli, clean, err := NewRabbitClient(RabbitMQConfig{
+		Host:              addr.IP.String(),
+		Port:              addr.Port,
+		Username:          "guest",
+		Password:          "guest",
+		Exchange:          "test",
+		Queue:             "driver",
+		ReconnectInterval: time.Millisecond * 50,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	received := make(chan string, 10)
+	if err := cli.Consume(ctx, []string{"data", "#"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		received <- string(payload)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	wait := func() (rabbitQueue, rabbitConsume) {
+		var q rabbitQueue
+		select {
+		case q = <-b.queues:
+		case <-ctx.Done():
+			t.Fatal("未声明队列")
+		}
+		select {
+		case c := <-b.consumes:
+			return q, c
+		case <-ctx.Done():
+			t.Fatal("未订阅队列")
+		}
+		return q, rabbitConsume{}
+	}
+	q, _ := wait()
+	if q.name != "driver.data.#" || !q.durable || q.autoDelete || q.exclusive {
+		t.Errorf("命名队列应持久化且不自动删除,实际为=%+v", q)
+	}
+
+	// 服务端断开后重连并重新订阅同一队列
+	b.drop()
+	q, c := wait()
+	if q.name != "driver.data.#" || !q.durable || q.autoDelete {
+		t.Errorf("重连后应重新声明同一持久化队列,实际为=%+v", q)
+	}
+	b.deliver(c, "data.d1", []byte("v"))
+	select {
+	case v := <-received:
+		if v != "v" {
+			t.Errorf("收到的消息应为=v,实际为=%s", v)
+		}
+	case <-ctx.Done():
+		t.Fatal("重连后未收到订阅的消息")
+	}
+	if subs := Subscriptions(cli); len(subs) != 1 {
+		t.Errorf("重连后订阅数量应为=1,实际为=%d", len(subs))
+	}
+}
+
+func TestRabbit_TemporaryQueue(t *testing.T) {
+	b := newFakeRabbit(t)
+	addr := b.ln.Addr().(*net.TCPAddr)
+	cli, clean, err := NewRabbitClient(RabbitMQConfig{Host: addr.IP.String(), Port: addr.Port, Exchange: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	if err := cli.Consume(context.Background(), []string{"data", "#"}, 2, func(string, []string, []byte) {}); err != nil {
+		t.Fatal(err)
+	}
+	if q := <-b.queues; q.name != "" || q.durable || !q.autoDelete || !q.exclusive {
+		t.Errorf("未配置队列前缀时应使用独占的临时队列,实际为=%+v", q)
+	}
+}
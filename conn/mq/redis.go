@@ -0,0 +1,408 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
+)
+
+var _ MQ = new(redisMQ)
+
+// redisPayloadField 消息内容在stream条目中的字段名
+const redisPayloadField = "payload"
+
+// redisHeaderPrefix 消息元数据在stream条目中的字段名前缀
+const redisHeaderPrefix = "header:"
+
+// RedisConfig redis stream配置参数
+type RedisConfig struct {
+	Host     string      `json:"host" yaml:"host"`
+	Port     int         `json:"port" yaml:"port"`
+	Username string      `json:"username" yaml:"username"`
+	Password string      `json:"password" yaml:"password"`
+	DB       int         `json:"db" yaml:"db"`
+	TLS      tlsx.Config `json:"tls" yaml:"tls"`
+	// Prefix stream名称前缀,stream名为{Prefix}:{topic},主题索引为{Prefix}:topics
+	Prefix string `json:"prefix" yaml:"prefix"`
+	// Group 消费组名称前缀,消费组名为{Group}:{订阅主题},同一消费组的多个实例分摊消息
+	Group    string `json:"group" yaml:"group"`
+	Consumer string `json:"consumer" yaml:"consumer"` // 消费者名称,默认为主机名和进程号
+	MaxLen   int64  `json:"maxLen" yaml:"maxLen"`     // 每个stream保留的最大消息数量(近似),默认10000
+	Count    int64  `json:"count" yaml:"count"`       // 每次读取的最大消息数量,默认100
+	// Block 读取消息的阻塞时间,默认1秒
+	Block time.Duration `json:"block" yaml:"block"`
+	// Refresh 通配符订阅查询主题索引及检查未确认消息的间隔,默认5秒
+	Refresh time.Duration `json:"refresh" yaml:"refresh"`
+	// NewTopicStart 通配符订阅后新发现的主题开始消费的消息ID,默认$只消费发现之后的消息,为0时从stream中最早的消息开始消费
+	NewTopicStart string `json:"newTopicStart" yaml:"newTopicStart"`
+	// ClaimIdle 未确认的消息空闲超过该时间后重新处理,包括处理异常的消息和已退出的消费者未确认的消息,默认30秒.需要Redis 6.2及以上版本
+	ClaimIdle time.Duration `json:"claimIdle" yaml:"claimIdle"`
+}
+
+func (a RedisConfig) DNS() string {
+	return fmt.Sprintf("%s:%d", a.Host, a.Port)
+}
+
+func (a RedisConfig) streamKey(topic string) string {
+	return a.Prefix + ":" + topic
+}
+
+func (a RedisConfig) topicIndexKey() string {
+	return a.Prefix + ":topics"
+}
+
+type redisMQ struct {
+	lostError
+	subscriptionRegistry[*redisSubscription]
+	lock         sync.RWMutex
+	cfg          RedisConfig
+	client       *redis.Client
+	callbacks    []Callback
+	disconnected atomic.Bool
+}
+
+// redisSubscription 订阅信息,streams只在读取协程中访问
+type redisSubscription struct {
+	topic   string
+	group   string
+	matcher TopicMatcher
+	splitN  int
+	handler Handler
+	streams map[string]struct{}
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+// NewRedisClient 创建Redis Stream消息队列
+func NewRedisClient(cfg RedisConfig) (MQ, func(), error) {
+	if cfg.Prefix == "" {
+		cfg.Prefix = "airiot:mq"
+	}
+	if cfg.Group == "" {
+		cfg.Group = "airiot"
+	}
+	if cfg.Consumer == "" {
+		host, _ := os.Hostname()
+		cfg.Consumer = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	if cfg.MaxLen <= 0 {
+		cfg.MaxLen = 10000
+	}
+	if cfg.Count <= 0 {
+		cfg.Count = 100
+	}
+	if cfg.Block <= 0 {
+		cfg.Block = time.Second
+	}
+	if cfg.Refresh <= 0 {
+		cfg.Refresh = time.Second * 5
+	}
+	if cfg.NewTopicStart == "" {
+		cfg.NewTopicStart = "$"
+	}
+	if cfg.ClaimIdle <= 0 {
+		cfg.ClaimIdle = time.Second * 30
+	}
+	tlsCfg, err := cfg.TLS.Load()
+	if err != nil {
+		return nil, nil, err
+	}
+	client := redis.NewClient(&redis.Options{
+		Addr:      cfg.DNS(),
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		DB:        cfg.DB,
+		TLSConfig: tlsCfg,
+	})
+	if err := client.Ping(context.Background()).Err(); err != nil {
+		_ = client.Close()
+		return nil, nil, fmt.Errorf("创建Redis客户端错误: %w", err)
+	}
+	cli := &redisMQ{cfg: cfg, client: client, callbacks: make([]Callback, 0)}
+	cleanFunc := func() {
+		for _, sub := range cli.clear() {
+			sub.cancel()
+			<-sub.done
+		}
+		if err := client.Close(); err != nil {
+			logger.Errorf("redis close error: %s", err.Error())
+		}
+	}
+	return cli, cleanFunc, nil
+}
+
+func (p *redisMQ) Callback(cb Callback) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.callbacks = append(p.callbacks, cb)
+}
+
+// markLost 读取错误时通知断开,恢复后通知连接
+func (p *redisMQ) markLost(err error) {
+	if p.disconnected.Swap(true) {
+		return
+	}
+	logger.Errorf("Redis Lost错误: %s", err.Error())
+	p.lostError.set(err)
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Lost(p); err != nil {
+			logger.Fatalf("lost callback err, %s", err)
+		}
+	}
+}
+
+func (p *redisMQ) markConnected() {
+	if !p.disconnected.Swap(false) {
+		return
+	}
+	logger.Infof("Redis 已重连")
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	for _, cb := range p.callbacks {
+		if err := cb.Connect(p); err != nil {
+			logger.Fatalf("connect callback err, %s", err)
+		}
+	}
+}
+
+func (p *redisMQ) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return p.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+// PublishWithHeader 写入主题对应的stream并记录到主题索引,元数据写入header:{key}字段
+func (p *redisMQ) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header Header) error {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	values := make([]interface{}, 0, 2+len(header)*2)
+	values = append(values, redisPayloadField, payload)
+	for k, v := range header {
+		values = append(values, redisHeaderPrefix+k, v)
+	}
+	_, err := p.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAdd(ctx, &redis.XAddArgs{
+			Stream: p.cfg.streamKey(topic),
+			MaxLen: p.cfg.MaxLen,
+			Approx: true,
+			Values: values,
+		})
+		pipe.SAdd(ctx, p.cfg.topicIndexKey(), topic)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("发送消息错误: %w", err)
+	}
+	return nil
+}
+
+func (p *redisMQ) Consume(ctx context.Context, topicParams []string, splitN int, handler Handler) error {
+	topic := strings.Join(topicParams, TOPICSEPWITHMQTT)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if _, ok := p.get(topicParams); ok {
+		return fmt.Errorf("主题 %s 已订阅", topic)
+	}
+	sub := &redisSubscription{
+		topic:   topic,
+		group:   p.cfg.Group + ":" + topic,
+		matcher: NewTopicMatcher(topic),
+		splitN:  splitN,
+		handler: handler,
+		streams: map[string]struct{}{},
+		done:    make(chan struct{}),
+	}
+	// 订阅时已存在的主题从最新消息开始消费
+	if err := p.refresh(ctx, sub, "$"); err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	sub.cancel = cancel
+	p.add(topicParams, splitN, sub)
+	go p.run(runCtx, sub)
+	return nil
+}
+
+func (p *redisMQ) UnSubscription(_ context.Context, topicParams []string) error {
+	sub, ok := p.remove(topicParams)
+	if !ok {
+		return nil
+	}
+	sub.cancel()
+	<-sub.done
+	return nil
+}
+
+// refresh 查询匹配订阅的主题,为新主题创建消费组并处理未确认的消息
+func (p *redisMQ) refresh(ctx context.Context, sub *redisSubscription, start string) error {
+	topics := []string{sub.topic}
+	if strings.ContainsAny(sub.topic, "+#") {
+		all, err := p.client.SMembers(ctx, p.cfg.topicIndexKey()).Result()
+		if err != nil {
+			return fmt.Errorf("查询主题索引错误: %w", err)
+		}
+		topics = topics[:0]
+		for _, topic := range all {
+			if sub.matcher.Match(topic) {
+				topics = append(topics, topic)
+			}
+		}
+	}
+	for _, topic := range topics {
+		key := p.cfg.streamKey(topic)
+		if _, ok := sub.streams[key]; ok {
+			continue
+		}
+		err := p.client.XGroupCreateMkStream(ctx, key, sub.group, start).Err()
+		if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+			return fmt.Errorf("创建消费组错误: 主题=%s. %w", topic, err)
+		}
+		if err != nil {
+			// 消费组已存在,先处理上次未确认的消息
+			if err := p.readPending(ctx, sub, key); err != nil {
+				return err
+			}
+		}
+		sub.streams[key] = struct{}{}
+	}
+	return nil
+}
+
+// readPending 处理本消费者未确认的消息,处理异常的消息跳过,由claimPending稍后重新处理
+func (p *redisMQ) readPending(ctx context.Context, sub *redisSubscription, key string) error {
+	start := "0"
+	for {
+		res, err := p.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sub.group,
+			Consumer: p.cfg.Consumer,
+			Streams:  []string{key, start},
+			Count:    p.cfg.Count,
+		}).Result()
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return fmt.Errorf("读取未确认消息错误: %w", err)
+		}
+		if len(res) == 0 || len(res[0].Messages) == 0 {
+			return nil
+		}
+		p.handle(ctx, sub, res)
+		start = res[0].Messages[len(res[0].Messages)-1].ID
+	}
+}
+
+// claimPending 认领空闲超过ClaimIdle的未确认消息并重新处理,处理异常时消息继续留在待确认列表中
+func (p *redisMQ) claimPending(ctx context.Context, sub *redisSubscription) error {
+	for key := range sub.streams {
+		start := "0-0"
+		for {
+			msgs, next, err := p.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+				Stream:   key,
+				Group:    sub.group,
+				Consumer: p.cfg.Consumer,
+				MinIdle:  p.cfg.ClaimIdle,
+				Start:    start,
+				Count:    p.cfg.Count,
+			}).Result()
+			if err != nil {
+				return fmt.Errorf("认领未确认消息错误: stream=%s. %w", key, err)
+			}
+			if len(msgs) > 0 {
+				p.handle(ctx, sub, []redis.XStream{{Stream: key, Messages: msgs}})
+			}
+			if next == "" || next == "0-0" {
+				break
+			}
+			start = next
+		}
+	}
+	return nil
+}
+
+// run 读取订阅的stream直到取消订阅
+func (p *redisMQ) run(ctx context.Context, sub *redisSubscription) {
+	defer close(sub.done)
+	refreshed := time.Now()
+	for ctx.Err() == nil {
+		if time.Since(refreshed) >= p.cfg.Refresh {
+			if err := p.refresh(ctx, sub, p.cfg.NewTopicStart); err != nil && ctx.Err() == nil {
+				logger.Errorf("Redis 订阅刷新错误: 主题=%s. %s", sub.topic, err.Error())
+			}
+			if err := p.claimPending(ctx, sub); err != nil && ctx.Err() == nil {
+				logger.Errorf("Redis 重新处理未确认消息错误: 主题=%s. %s", sub.topic, err.Error())
+			}
+			refreshed = time.Now()
+		}
+		if len(sub.streams) == 0 {
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.cfg.Block):
+			}
+			continue
+		}
+		streams := make([]string, 0, len(sub.streams)*2)
+		for key := range sub.streams {
+			streams = append(streams, key)
+		}
+		for range sub.streams {
+			streams = append(streams, ">")
+		}
+		res, err := p.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    sub.group,
+			Consumer: p.cfg.Consumer,
+			Streams:  streams,
+			Count:    p.cfg.Count,
+			Block:    p.cfg.Block,
+		}).Result()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil && !errors.Is(err, redis.Nil) {
+			p.markLost(err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(p.cfg.Block):
+			}
+			continue
+		}
+		p.markConnected()
+		p.handle(ctx, sub, res)
+	}
+}
+
+// handle 处理消息,处理完成后确认,处理异常时不确认
+func (p *redisMQ) handle(ctx context.Context, sub *redisSubscription, res []redis.XStream) {
+	for _, stream := range res {
+		topic := strings.TrimPrefix(stream.Stream, p.cfg.Prefix+":")
+		for _, msg := range stream.Messages {
+			payload, _ := msg.Values[redisPayloadField].(string)
+			encoding, _ := msg.Values[redisHeaderPrefix+HeaderContentEncoding].(string)
+			// 无法解压的消息重新读取也无法处理,直接确认
+			if b, ok := decompressPayload(topic, encoding, []byte(payload)); ok && !p.call(sub, topic, b) {
+				continue
+			}
+			if err := p.client.XAck(ctx, stream.Stream, sub.group, msg.ID).Err(); err != nil {
+				logger.Errorf("Redis 确认消息错误: 主题=%s. %s", topic, err.Error())
+			}
+		}
+	}
+}
+
+func (p *redisMQ) call(sub *redisSubscription, topic string, payload []byte) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Errorf("Redis 消息处理异常: 主题=%s. %v", topic, r)
+			ok = false
+		}
+	}()
+	sub.handler(topic, strings.SplitN(topic, TOPICSEPWITHMQTT, sub.splitN), payload)
+	return true
+}
@@ -0,0 +1,198 @@
+package mq
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newTestRedis(t *testing.T) (MQ, func()) {
+	s := miniredis.RunT(t)
+	port, _ := strconv.Atoi(s.Port())
+	cli, clean, err := NewRedisClient(RedisConfig{
+		Host:    s.Host(),
+		Port:    port,
+		Block:     time.Millisecond * 50,
+		Refresh:   time.Millisecond * 50,
+		ClaimIdle: time.Millisecond * 100,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cli, clean
+}
+
+func TestRedis(t *testing.T) {
+	cli, clean := newTestRedis(t)
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	received := make(chan []string, 10)
+	if err := cli.Consume(ctx, []string{"data", "p1", "+", "#"}, 4, func(topic string, topicSplit []string, payload []byte) {
+		received <- append(topicSplit, string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// 订阅后新出现的主题通过主题索引发现,从发现之后的消息开始消费
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("v0")); err != nil {
+		t.Fatal(err)
+	}
+	stream := "airiot:mq:data/p1/t1/d1"
+	group := "airiot:data/p1/+/#"
+	deadline := time.Now().Add(time.Second)
+	for {
+		groups, err := cli.(*redisMQ).client.XInfoGroups(ctx, stream).Result()
+		if err == nil && len(groups) == 1 && groups[0].Name == group {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("未发现新主题: %v %v", groups, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p2", "t1", "d1"}, []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if len(msg) != 5 || msg[2] != "t1" || msg[3] != "d1" || msg[4] != "v" {
+			t.Errorf("收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到订阅的消息")
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("不应收到未匹配主题的消息: %v", msg)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// 处理完成后确认消息
+	deadline = time.Now().Add(time.Second)
+	for {
+		pending, err := cli.(*redisMQ).client.XPending(ctx, stream, group).Result()
+		if err == nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("消息未确认: %v %v", pending, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+
+	if err := cli.UnSubscription(ctx, []string{"data", "p1", "+", "#"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"data", "p1", "t1", "d1"}, []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		t.Errorf("取消订阅后不应收到消息: %v", msg)
+	case <-time.After(time.Millisecond * 200):
+	}
+
+	// 重新订阅时消费组已存在,继续消费取消订阅期间的消息
+	if err := cli.Consume(ctx, []string{"data", "p1", "+", "#"}, 4, func(topic string, topicSplit []string, payload []byte) {
+		received <- append(topicSplit, string(payload))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case msg := <-received:
+		if msg[4] != "v2" {
+			t.Errorf("重新订阅后收到的消息错误: %v", msg)
+		}
+	case <-ctx.Done():
+		t.Fatal("重新订阅后未收到消息")
+	}
+}
+
+func TestRedisHandlerPanic(t *testing.T) {
+	cli, clean := newTestRedis(t)
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	calls := make(chan struct{}, 10)
+	if err := cli.Consume(ctx, []string{"cmd", "d1"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		calls <- struct{}{}
+		panic("处理失败")
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"cmd", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calls:
+	case <-ctx.Done():
+		t.Fatal("未收到订阅的消息")
+	}
+	// 处理异常的消息不确认,重新订阅时再次投递
+	if err := cli.UnSubscription(ctx, []string{"cmd", "d1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Consume(ctx, []string{"cmd", "d1"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		calls <- struct{}{}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-calls:
+	case <-ctx.Done():
+		t.Fatal("未确认的消息未重新投递")
+	}
+}
+
+func TestRedisClaimPending(t *testing.T) {
+	cli, clean := newTestRedis(t)
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	calls := make(chan time.Time, 10)
+	var failed atomic.Bool
+	if err := cli.Consume(ctx, []string{"cmd", "d1"}, 2, func(topic string, topicSplit []string, payload []byte) {
+		calls <- time.Now()
+		if failed.CompareAndSwap(false, true) {
+			panic("处理失败")
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"cmd", "d1"}, []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	// 处理异常的消息空闲超过ClaimIdle后在运行中重新处理
+	times := make([]time.Time, 2)
+	for i := range times {
+		select {
+		case times[i] = <-calls:
+		case <-ctx.Done():
+			t.Fatal("处理异常的消息未重新处理")
+		}
+	}
+	if idle := times[1].Sub(times[0]); idle < time.Millisecond*100 {
+		t.Errorf("消息应空闲超过ClaimIdle后重新处理,实际间隔=%s", idle)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		pending, err := cli.(*redisMQ).client.XPending(ctx, "airiot:mq:cmd/d1", "airiot:cmd/d1").Result()
+		if err == nil && pending.Count == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("重新处理成功后消息未确认: %v %v", pending, err)
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+}
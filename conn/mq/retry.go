@@ -0,0 +1,105 @@
+package mq
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/air-iot/json"
+	"github.com/air-iot/logger"
+)
+
+// ErrorHandler 返回错误的消息处理函数,返回错误或处理异常时按RetryConfig重试
+type ErrorHandler func(topic string, topicSplit []string, payload []byte) error
+
+// RetryConfig 消息处理重试配置
+type RetryConfig struct {
+	MaxRetries int           `json:"maxRetries" yaml:"maxRetries"` // 最大重试次数,为0时不重试
+	Backoff    time.Duration `json:"backoff" yaml:"backoff"`       // 首次重试间隔,之后每次加倍,默认1秒
+	MaxBackoff time.Duration `json:"maxBackoff" yaml:"maxBackoff"` // 最大重试间隔,默认30秒
+	MaxElapsed time.Duration `json:"maxElapsed" yaml:"maxElapsed"` // 重试总时长上限,超过后不再重试,为0时不限制.Kafka限制为会话超时的一半
+	// DeadLetter 死信主题前缀,重试后仍失败的消息以DeadLetter格式发送到{DeadLetter}/{原主题},为空时丢弃
+	DeadLetter []string `json:"deadLetter" yaml:"deadLetter"`
+}
+
+// DeadLetter 死信消息
+type DeadLetter struct {
+	Topic    string `json:"topic"`    // 原主题
+	Payload  []byte `json:"payload"`  // 原消息内容(已解压)
+	Error    string `json:"error"`    // 最后一次处理错误
+	Attempts int    `json:"attempts"` // 处理次数
+	Time     int64  `json:"time"`     // 进入死信的时间 毫秒数
+}
+
+// retryConsumer 自行实现重试订阅的消息队列,如RabbitMQ重试订阅时使用手动确认,Kafka限制重试总时长
+type retryConsumer interface {
+	ConsumeWithRetry(ctx context.Context, topicParams []string, splitN int, cfg RetryConfig, handler ErrorHandler) error
+}
+
+// ConsumeWithRetry 订阅消息,handler返回错误时按配置重试,超过重试次数后发送到死信主题.
+// 重试在订阅的处理协程中进行,重试期间不处理该订阅的后续消息
+func ConsumeWithRetry(ctx context.Context, m MQ, topicParams []string, splitN int, cfg RetryConfig, handler ErrorHandler) error {
+	if c, ok := m.(retryConsumer); ok {
+		return c.ConsumeWithRetry(ctx, topicParams, splitN, cfg, handler)
+	}
+	return m.Consume(ctx, topicParams, splitN, newRetryHandler(m, cfg, handler))
+}
+
+// newRetryHandler 转换为Handler,死信通过m发送
+func newRetryHandler(m MQ, cfg RetryConfig, handler ErrorHandler) Handler {
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Second * 30
+	}
+	return func(topic string, topicSplit []string, payload []byte) {
+		start := time.Now()
+		backoff := cfg.Backoff
+		attempts := 0
+		var err error
+		for {
+			attempts++
+			if err = callErrorHandler(handler, topic, topicSplit, payload); err == nil {
+				return
+			}
+			if attempts > cfg.MaxRetries {
+				break
+			}
+			if cfg.MaxElapsed > 0 && time.Since(start)+backoff > cfg.MaxElapsed {
+				logger.Warnf("消息处理错误: 主题=%s,重试总时长将超过%s,不再重试", topic, cfg.MaxElapsed)
+				break
+			}
+			logger.Warnf("消息处理错误: 主题=%s,第%d次重试. %s", topic, attempts, err.Error())
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > cfg.MaxBackoff {
+				backoff = cfg.MaxBackoff
+			}
+		}
+		logger.Errorf("消息处理失败: 主题=%s,处理次数=%d. %s", topic, attempts, err.Error())
+		if len(cfg.DeadLetter) == 0 {
+			return
+		}
+		b, mErr := json.Marshal(&DeadLetter{Topic: topic, Payload: payload, Error: err.Error(), Attempts: attempts, Time: time.Now().UnixMilli()})
+		if mErr != nil {
+			logger.Errorf("死信消息序列化错误: 主题=%s. %s", topic, mErr.Error())
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.MaxBackoff)
+		defer cancel()
+		deadTopic := append(append(make([]string, 0, len(cfg.DeadLetter)+len(topicSplit)), cfg.DeadLetter...), topicSplit...)
+		if err := m.Publish(ctx, deadTopic, b); err != nil {
+			logger.Errorf("发送死信消息错误: 主题=%s. %s", topic, err.Error())
+		}
+	}
+}
+
+// callErrorHandler 调用handler,处理异常时转换为错误
+func callErrorHandler(handler ErrorHandler, topic string, topicSplit []string, payload []byte) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("处理异常: %v", r)
+		}
+	}()
+	return handler(topic, topicSplit, payload)
+}
@@ -0,0 +1,83 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/air-iot/json"
+)
+
+func TestConsumeWithRetry(t *testing.T) {
+	cli, clean, err := NewMemoryClient(MemoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	deadLetters := make(chan []byte, 10)
+	if err := cli.Consume(ctx, []string{"dlq", "#"}, 3, func(topic string, topicSplit []string, payload []byte) {
+		if topic != "dlq/cmd/d1" {
+			t.Errorf("死信主题应为=dlq/cmd/d1,实际为=%s", topic)
+		}
+		deadLetters <- payload
+	}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := RetryConfig{MaxRetries: 2, Backoff: time.Millisecond, DeadLetter: []string{"dlq"}}
+	attempts := make(chan string, 10)
+	if err := ConsumeWithRetry(ctx, cli, []string{"cmd", "+"}, 2, cfg, func(topic string, topicSplit []string, payload []byte) error {
+		attempts <- string(payload)
+		switch string(payload) {
+		case "panic":
+			panic("处理异常")
+		case "fail":
+			return errors.New("处理失败")
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cli.Publish(ctx, []string{"cmd", "d1"}, []byte("ok")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cli.Publish(ctx, []string{"cmd", "d1"}, []byte("fail")); err != nil {
+		t.Fatal(err)
+	}
+	var letter DeadLetter
+	select {
+	case b := <-deadLetters:
+		if err := json.Unmarshal(b, &letter); err != nil {
+			t.Fatal(err)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到死信消息")
+	}
+	if letter.Topic != "cmd/d1" || string(letter.Payload) != "fail" || letter.Error != "处理失败" || letter.Attempts != 3 {
+		t.Errorf("死信消息错误: %+v", letter)
+	}
+	// 成功处理1次,失败处理3次
+	if n := len(attempts); n != 4 {
+		t.Errorf("处理次数应为=4,实际为=%d", n)
+	}
+
+	// 处理异常同样重试并进入死信
+	if err := cli.Publish(ctx, []string{"cmd", "d1"}, []byte("panic")); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case b := <-deadLetters:
+		if err := json.Unmarshal(b, &letter); err != nil {
+			t.Fatal(err)
+		}
+		if letter.Attempts != 3 || letter.Error == "" {
+			t.Errorf("死信消息错误: %+v", letter)
+		}
+	case <-ctx.Done():
+		t.Fatal("未收到死信消息")
+	}
+}
@@ -0,0 +1,36 @@
+package mq
+
+import "strings"
+
+// MatchTopic 判断topic是否匹配MQTT格式的订阅,支持+单层和#多层通配符
+func MatchTopic(subscription, topic string) bool {
+	return NewTopicMatcher(subscription).Match(topic)
+}
+
+// TopicMatcher 预先拆分的MQTT格式订阅,用于重复匹配
+type TopicMatcher []string
+
+// NewTopicMatcher 创建订阅匹配
+func NewTopicMatcher(subscription string) TopicMatcher {
+	return strings.Split(subscription, TOPICSEPWITHMQTT)
+}
+
+// Match 判断topic是否匹配订阅
+func (m TopicMatcher) Match(topic string) bool {
+	topics := strings.Split(topic, TOPICSEPWITHMQTT)
+	for i, s := range m {
+		switch s {
+		case "#":
+			return i == len(m)-1
+		case "+":
+			if i >= len(topics) {
+				return false
+			}
+		default:
+			if i >= len(topics) || topics[i] != s {
+				return false
+			}
+		}
+	}
+	return len(m) == len(topics)
+}
@@ -12,7 +12,6 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"os/signal"
-	"runtime"
 	"strings"
 	"sync"
 	"syscall"
@@ -22,9 +21,11 @@ import (
 	"github.com/air-iot/logger"
 	"github.com/shopspring/decimal"
 	"github.com/spf13/pflag"
-	"github.com/spf13/viper"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/air-iot/sdk-go/v4/conn/mq"
+	"github.com/air-iot/sdk-go/v4/driver/buffer"
 	"github.com/air-iot/sdk-go/v4/driver/convert"
 	"github.com/air-iot/sdk-go/v4/driver/entity"
 	"github.com/air-iot/sdk-go/v4/utils/numberx"
@@ -37,7 +38,18 @@ type App interface {
 	WriteWarning(context.Context, entity.Warn) error
 	WriteWarningRecovery(ctx context.Context, tableId, dataId string, w entity.WarnRecovery) error
 	FindDevice(ctx context.Context, table, id string, ret interface{}) error
+	GetDevice(table, id string) (*Device, bool)
+	ListDevices() []*Device
+	GetTag(table, id, tagId string) (entity.Tag, bool)
+	Schedule(cfg SchedulerConfig, poll PollFunc)
+	SetDeviceOnline(ctx context.Context, table, id string) error
+	SetDeviceOffline(ctx context.Context, table, id, reason string) error
+	GetDeviceStatus(table, id string) DeviceStatus
+	ReportError(table, id string, err error, names ...string) (ErrorType, error)
+	ErrorCounts(table, id string) map[ErrorType]uint64
 	RunLog(context.Context, entity.Log) error
+	Flush(ctx context.Context) error
+	Run(ctx context.Context, driver Driver) error
 	UpdateTableData(ctx context.Context, table, id string, custom map[string]interface{}) error
 	LogDebug(table, id string, msg interface{})
 	LogInfo(table, id string, msg interface{})
@@ -56,119 +68,195 @@ const (
 // app 数据采集类
 type app struct {
 	mq      mq.MQ
+	codec   mq.Codec
+	header  mq.Header
 	stopped bool
 	cli     *Client
 	clean   func()
 
 	cacheValue sync.Map
+	buffer     *buffer.Buffer
+	pipeline   *pipeline
+
+	schedLock sync.Mutex
+	scheduler *Scheduler
+	status    *statusTracker
+
+	errorStats errorStats
+	metrics    *driverMetrics
 }
 
-func init() {
-	// 设置随机数种子
-	//rand.Seed(time.Now().Unix())
-	runtime.GOMAXPROCS(runtime.NumCPU())
-	pflag.String("project", "default", "项目id")
-	pflag.String("serviceId", "", "服务id")
-	pflag.String("groupId", "", "组id")
-	cfgPath := pflag.String("config", "./etc/", "配置文件")
-	pflag.Parse()
-	viper.SetDefault("log.level", 4)
-	viper.SetDefault("log.format", "json")
-	viper.SetDefault("log.output", "stdout")
-	viper.SetDefault("mq.type", "mqtt")
-	viper.SetDefault("mq.timeout", "60s")
-	viper.SetDefault("mq.mqtt.host", "mqtt")
-	viper.SetDefault("mq.mqtt.port", 1883)
-	viper.SetDefault("mq.mqtt.username", "admin")
-	viper.SetDefault("mq.mqtt.password", "public")
-	viper.SetDefault("mq.mqtt.keepAlive", 60)
-	viper.SetDefault("mq.mqtt.connectTimeout", 20)
-	viper.SetDefault("mq.mqtt.protocolVersion", 4)
-	viper.SetDefault("mq.rabbit.host", "rabbit")
-	viper.SetDefault("mq.rabbit.port", 5672)
-	viper.SetDefault("mq.rabbit.username", "admin")
-	viper.SetDefault("mq.rabbit.password", "public")
-	viper.SetDefault("mq.kafka.brokers", []string{"kafka:9092"})
-	viper.SetDefault("driverGrpc.host", "driver")
-	viper.SetDefault("driverGrpc.port", 9224)
-	viper.SetDefault("driverGrpc.health.requestTime", "10s")
-	viper.SetDefault("driverGrpc.health.retry", 3)
-	viper.SetDefault("driverGrpc.waitTime", "5s")
-	viper.SetDefault("driverGrpc.timeout", "600s")
-	viper.SetDefault("driverGrpc.limit", 100)
-	viper.SetConfigType("env")
-	viper.AutomaticEnv()
-	viper.SetConfigType("yaml")
-	viper.SetConfigName("config")
-	viper.AddConfigPath(*cfgPath)
-	if err := viper.BindPFlags(pflag.CommandLine); err != nil {
-		log.Fatalln("读取命令行参数错误,", err.Error())
-	}
-	if err := viper.ReadInConfig(); err != nil {
-		log.Fatalln("读取配置错误,", err.Error())
-	}
-	if err := viper.Unmarshal(Cfg); err != nil {
-		log.Fatalln("配置解析错误: ", err.Error())
-	}
-}
-
-// NewApp 创建App
-func NewApp() App {
-	a := new(app)
-	if Cfg.ServiceID == "" {
-		panic("服务id不能为空")
+// Option 创建App的可选参数
+type Option func(*app)
+
+// WithMQ 使用传入的消息队列,不再根据配置创建
+func WithMQ(m mq.MQ) Option {
+	return func(a *app) {
+		a.mq = m
+	}
+}
+
+// NewApp 创建App,从命令行参数、环境变量和配置文件(默认./etc/config.yaml)读取配置,读取配置或创建失败时退出进程
+func NewApp(opts ...Option) App {
+	cfg, err := LoadConfig(WithFlagSet(pflag.CommandLine, os.Args[1:]))
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	a, err := NewAppWithConfig(cfg, opts...)
+	if err != nil {
+		log.Fatalln(err.Error())
+	}
+	return a
+}
+
+// NewAppWithConfig 使用传入的配置创建App.
+// 配置保存在全局变量Cfg中,每个进程只支持一个App,再次创建会替换之前App使用的配置
+func NewAppWithConfig(cfg *Config, opts ...Option) (App, error) {
+	if cfg.ServiceID == "" {
+		return nil, errors.New("服务id不能为空")
+	}
+	if cfg.Driver.ID == "" || cfg.Driver.Name == "" {
+		return nil, errors.New("驱动id或name不能为空")
 	}
-	if Cfg.Driver.ID == "" || Cfg.Driver.Name == "" {
-		panic("驱动id或name不能为空")
+	codec, err := mq.NewCodec(cfg.MQ.Codec)
+	if err != nil {
+		return nil, fmt.Errorf("初始化消息编码错误: %w", err)
+	}
+	Cfg = cfg
+	a := new(app)
+	for _, opt := range opts {
+		opt(a)
 	}
 	Cfg.Log.Syslog.ProjectId = Cfg.Project
 	Cfg.Log.Syslog.ServiceName = fmt.Sprintf("%s-%s-%s", Cfg.Project, Cfg.ServiceID, Cfg.Driver.ID)
 	logger.InitLogger(Cfg.Log)
 	logger.Debugf("配置: %+v", *Cfg)
-	mqConn, clean, err := mq.NewMQ(Cfg.MQ)
-	if err != nil {
-		panic(fmt.Errorf("初始化消息队列错误,%s", err))
+	a.codec = codec
+	a.metrics = newDriverMetrics()
+	clean := func() {}
+	if a.mq == nil {
+		mqConn, mqClean, err := mq.NewMQ(Cfg.MQ)
+		if err != nil {
+			return nil, fmt.Errorf("初始化消息队列错误: %w", err)
+		}
+		a.mq = mqConn
+		clean = mqClean
 	}
-	a.mq = mqConn
 	a.clean = func() {
 		clean()
 	}
+	// json编码不发送content-type,订阅方缺省按json解码;其他编码需要消息队列支持元数据
+	if codec.Name() != mq.CodecJSON {
+		if !mq.HeadersSupported(a.mq) {
+			a.clean()
+			return nil, fmt.Errorf("消息队列 %T 不支持消息元数据,不能使用%s编码", a.mq, codec.Name())
+		}
+		a.header = mq.Header{mq.HeaderContentType: codec.ContentType()}
+	}
 	a.cacheValue = sync.Map{}
+	if Cfg.Buffer.Enable {
+		buf, err := buffer.New(Cfg.Buffer)
+		if err != nil {
+			a.clean()
+			return nil, fmt.Errorf("初始化离线缓存错误: %w", err)
+		}
+		a.buffer = buf
+		ctx, cancel := context.WithCancel(context.Background())
+		a.clean = func() {
+			cancel()
+			clean()
+			if err := buf.Close(); err != nil {
+				logger.Errorf("关闭离线缓存: %v", err)
+			}
+		}
+		a.mq.Callback(a)
+		go a.replayLoop(ctx)
+	}
+	if Cfg.Tracing.Enable {
+		shutdown, err := initTracing(Cfg.Tracing)
+		if err != nil {
+			a.clean()
+			return nil, fmt.Errorf("初始化链路追踪错误: %w", err)
+		}
+		clean := a.clean
+		a.clean = func() {
+			clean()
+			shutdown()
+		}
+	}
+	a.status = newStatusTracker(Cfg.Status, a.commitStatus)
+	if Cfg.Status.Enable {
+		ctx, cancel := context.WithCancel(context.Background())
+		clean := a.clean
+		a.clean = func() {
+			cancel()
+			clean()
+		}
+		go a.status.run(ctx)
+	}
+	if Cfg.Pipeline.Enable {
+		a.pipeline = newPipeline(Cfg.Pipeline, Cfg.MQ.Timeout, a.publish)
+	}
 	if Cfg.Pprof.Enable {
 		go func() {
-			//  路径/debug/pprof/
+			//  路径/debug/pprof/,指标查询路径/metrics
 			addr := net.JoinHostPort(Cfg.Pprof.Host, Cfg.Pprof.Port)
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", a.metrics.handler())
+			mux.Handle("/", http.DefaultServeMux)
 			logger.Infof("pprof启动: 地址=%s", addr)
-			if err := http.ListenAndServe(addr, nil); err != nil {
+			if err := http.ListenAndServe(addr, mux); err != nil {
 				logger.Errorf("pprof启动: 地址=%s. %v", addr, err)
 				return
 			}
 		}()
 	}
-	return a
+	return a, nil
 }
 
-// Start 开始服务
+// Start 开始服务,收到退出信号后停止服务并退出进程
 func (a *app) Start(driver Driver) {
-	a.stopped = false
-	cli := Client{cacheConfig: sync.Map{}, cacheConfigNum: sync.Map{}}
-	a.cli = cli.Start(a, driver)
+	ctx, cancel := context.WithCancel(context.Background())
 	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, syscall.SIGTERM, syscall.SIGINT, syscall.SIGKILL)
-	sig := <-ch
-	close(ch)
-	if err := driver.Stop(context.Background(), a); err != nil {
+	go func() {
+		sig := <-ch
+		logger.Debugf("关闭服务: 信号=%v", sig)
+		cancel()
+	}()
+	if err := a.Run(ctx, driver); err != nil {
+		logger.Warnf("关闭服务: %v", err.Error())
+	}
+	os.Exit(0)
+}
+
+// Run 连接驱动管理并运行驱动,上下文结束后停止驱动并释放资源
+func (a *app) Run(ctx context.Context, driver Driver) error {
+	a.stopped = false
+	cli := Client{cacheConfig: sync.Map{}, cacheConfigNum: sync.Map{}, metrics: a.metrics}
+	a.cli = cli.Start(a, driver)
+	<-ctx.Done()
+	a.stopSchedule()
+	var err error
+	if err = driver.Stop(context.Background(), a); err != nil {
 		logger.Warnf("驱动停止: %v", err.Error())
 	}
 	cli.Stop()
+	flushCtx, cancel := context.WithTimeout(context.Background(), Cfg.MQ.Timeout)
+	if err := a.Flush(flushCtx); err != nil {
+		logger.Warnf("发送队列数据: %v", err.Error())
+	}
+	cancel()
 	a.stop()
-	logger.Debugf("关闭服务: 信号=%v", sig)
-	os.Exit(0)
+	return err
 }
 
 // Stop 服务停止
 func (a *app) stop() {
 	a.stopped = true
+	if a.pipeline != nil {
+		a.pipeline.close()
+	}
 	if a.clean != nil {
 		a.clean()
 	}
@@ -210,12 +298,21 @@ func (a *app) WritePoints(ctx context.Context, p entity.Point) error {
 }
 
 func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) error {
-	ctxTimeout, cancelTimeout := context.WithTimeout(context.Background(), Cfg.MQ.Timeout)
+	ctxTimeout, cancelTimeout := context.WithTimeout(trace.ContextWithSpan(context.Background(), trace.SpanFromContext(ctx)), Cfg.MQ.Timeout)
 	defer cancelTimeout()
 	fields := make(map[string]interface{})
 	newLogger := logger.WithContext(ctx)
+	if p.UnixTime == 0 {
+		p.UnixTime = time.Now().Local().UnixMilli()
+	}
+	suppressed := 0
 	for _, field := range p.Fields {
 		if field.Value == nil {
+			// 数据质量不正常时只发送数据质量,如通讯失败
+			if !field.Quality.IsGood() && strings.TrimSpace(field.Tag.ID) != "" {
+				setQuality(fields, field.Tag.ID, field.Quality, field.Reason)
+				continue
+			}
 			newLogger.Warnf("存数据点: 设备表=%s,设备=%s. 设备数据点值为空", tableId, p.ID)
 			continue
 		}
@@ -275,6 +372,7 @@ func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) e
 			value = decimal.NewFromInt(valueTmp)
 		case []byte:
 			fields[tag.ID] = fmt.Sprintf("hex__%s", hex.EncodeToString(valueTmp))
+			setQuality(fields, tag.ID, field.Quality, field.Reason)
 			continue
 		default:
 			valTmp, err := numberx.GetValueByType("", field.Value)
@@ -284,6 +382,7 @@ func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) e
 				continue
 			}
 			fields[tag.ID] = valTmp
+			setQuality(fields, tag.ID, field.Quality, field.Reason)
 			continue
 		}
 		val := convert.Value(&tag, value)
@@ -297,7 +396,21 @@ func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) e
 				preVal = &preValue
 			}
 		}
-		newVal, rawVal, invalidType, save := convert.Range(tag.Range, preVal, &val)
+		newVal, rawVal, invalidType, save, quality, reason := convert.RangeQuality(tag.Range, preVal, &val)
+		if field.Quality.Worse(quality) {
+			quality, reason = field.Quality, field.Reason
+		}
+		if newVal != nil && rawVal == nil && invalidType == "" && quality.IsGood() && !a.deadband(cacheKey, tag.Deadband, *newVal, p.UnixTime) {
+			if save {
+				a.cacheValue.Store(cacheKey, newVal)
+			}
+			suppressed++
+			a.metrics.dropField(tableId, "deadband")
+			continue
+		}
+		if newVal == nil {
+			a.metrics.dropField(tableId, "range")
+		}
 		if newVal != nil {
 			valTmp, err := numberx.GetValueByType("", newVal)
 			if err != nil {
@@ -305,6 +418,7 @@ func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) e
 				logger.WithContext(errCtx).Errorf("存数据点: 设备表=%s,设备=%s,数据点=%s. 设备数据点转类型失败", tableId, p.ID, tag.ID)
 			} else {
 				fields[tag.ID] = valTmp
+				setQuality(fields, tag.ID, quality, reason)
 				if save {
 					a.cacheValue.Store(cacheKey, newVal)
 				}
@@ -324,22 +438,137 @@ func (a *app) writePoints(ctx context.Context, tableId string, p entity.Point) e
 		}
 	}
 	if len(fields) == 0 {
+		if suppressed > 0 {
+			// 数据点均未超过死区,不上报
+			return nil
+		}
 		return errors.New("数据点为空值")
 	}
-	if p.UnixTime == 0 {
-		p.UnixTime = time.Now().Local().UnixMilli()
-	}
-	b, err := json.Marshal(&entity.WritePoint{ID: p.ID, CID: p.CID, Source: "device", UnixTime: p.UnixTime, Fields: fields, FieldTypes: p.FieldTypes})
+	point := &entity.WritePoint{ID: p.ID, CID: p.CID, Source: "device", UnixTime: p.UnixTime, Fields: fields, FieldTypes: p.FieldTypes}
+	b, err := a.codec.Marshal(point)
 	if err != nil {
 		return err
 	}
 	if logger.IsLevelEnabled(logger.DebugLevel) {
-		newLogger.Debugf("存数据点: 设备表=%s,设备=%s,数据=%s. 保存数据成功", tableId, p.ID, string(b))
+		if a.codec.Name() == mq.CodecJSON {
+			newLogger.Debugf("存数据点: 设备表=%s,设备=%s,数据=%s. 保存数据成功", tableId, p.ID, string(b))
+		} else {
+			newLogger.Debugf("存数据点: 设备表=%s,设备=%s,编码=%s,数据=%+v. 保存数据成功", tableId, p.ID, a.codec.Name(), *point)
+		}
+	}
+	if Cfg.Status.Enable && a.status != nil {
+		a.status.observe(tableId, p.ID, nil)
 	}
-	return a.mq.Publish(ctxTimeout, []string{"data", Cfg.Project, tableId, p.ID}, b)
+	if a.pipeline != nil {
+		return a.pipeline.push(ctxTimeout, []string{"data", Cfg.Project, tableId, p.ID}, b)
+	}
+	return a.publish(ctxTimeout, []string{"data", Cfg.Project, tableId, p.ID}, b)
 	//return nil
 }
 
+// setQuality 数据质量不正常时写入数据质量字段
+func setQuality(fields map[string]interface{}, tagId string, quality entity.Quality, reason entity.QualityReason) {
+	if quality.IsGood() {
+		return
+	}
+	fields[fmt.Sprintf("%s__quality", tagId)] = string(quality)
+	if reason != "" {
+		fields[fmt.Sprintf("%s__quality__reason", tagId)] = string(reason)
+	}
+}
+
+// Flush 等待异步发送队列中的数据点发送完成
+func (a *app) Flush(ctx context.Context) error {
+	if a.pipeline == nil {
+		return nil
+	}
+	return a.pipeline.flush(ctx)
+}
+
+// publish 发送数据点,启用离线缓存时发送失败的数据写入缓存,消息队列重连后按顺序补发
+func (a *app) publish(ctx context.Context, topic []string, payload []byte) (err error) {
+	if trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		var span trace.Span
+		ctx, span = tracer().Start(ctx, "mq.Publish", trace.WithSpanKind(trace.SpanKindProducer),
+			trace.WithAttributes(attribute.String("topic", strings.Join(topic, "/"))))
+		defer func() {
+			endSpan(span, err)
+		}()
+	}
+	if a.buffer == nil {
+		err = mq.PublishWithHeader(ctx, a.mq, topic, payload, a.header)
+		a.metrics.observePublish(topic, err)
+		return err
+	}
+	// 缓存中有未补发的数据时直接追加,保证数据顺序
+	if !a.buffer.Empty() {
+		return a.buffer.Push(topic, payload)
+	}
+	err = mq.PublishWithHeader(ctx, a.mq, topic, payload, a.header)
+	a.metrics.observePublish(topic, err)
+	if err != nil {
+		if bufErr := a.buffer.Push(topic, payload); bufErr != nil {
+			return fmt.Errorf("%w; 写入离线缓存错误: %v", err, bufErr)
+		}
+		logger.Warnf("存数据点: topic=%v. 发送失败,已写入离线缓存: %v", topic, err)
+	}
+	return nil
+}
+
+// replay 补发离线缓存中的数据
+func (a *app) replay() {
+	if a.buffer == nil || a.buffer.Empty() {
+		return
+	}
+	logger.Infof("离线缓存: 开始补发,缓存大小=%d", a.buffer.Size())
+	if err := a.buffer.Replay(context.Background(), func(ctx context.Context, topic []string, payload []byte) error {
+		ctxTimeout, cancelTimeout := context.WithTimeout(ctx, Cfg.MQ.Timeout)
+		defer cancelTimeout()
+		err := mq.PublishWithHeader(ctxTimeout, a.mq, topic, payload, a.header)
+		a.metrics.observePublish(topic, err)
+		return err
+	}); err != nil {
+		logger.Errorf("离线缓存: 补发错误,剩余缓存大小=%d: %v", a.buffer.Size(), err)
+		return
+	}
+	logger.Infof("离线缓存: 补发完成")
+}
+
+// replayLoop 定时补发离线缓存,防止消息队列未触发重连回调时数据滞留
+func (a *app) replayLoop(ctx context.Context) {
+	a.replay()
+	interval := Cfg.Buffer.RetryInterval
+	if interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.replay()
+		}
+	}
+}
+
+// Connect 消息队列连接成功后补发离线缓存
+func (a *app) Connect(mq.MQ) error {
+	go a.replay()
+	return nil
+}
+
+// Lost 消息队列断开连接
+func (a *app) Lost(m mq.MQ) error {
+	if err := mq.LostErr(m); err != nil {
+		logger.Warnf("离线缓存: 消息队列连接断开: %v. 发送失败的数据点将写入离线缓存", err)
+		return nil
+	}
+	logger.Warnf("离线缓存: 消息队列连接断开,发送失败的数据点将写入离线缓存")
+	return nil
+}
+
 func (a *app) WriteWarning(ctx context.Context, w entity.Warn) error {
 	//ctx = logger.NewModuleContext(ctx, entity.MODULE_WARN)
 	tableId := w.TableId
@@ -441,6 +670,73 @@ func (a *app) FindDevice(ctx context.Context, table, id string, ret interface{})
 	return a.cli.FindDevice(ctx, table, id, ret)
 }
 
+// GetDevice 查询驱动配置中的设备,表id为空时按设备id查找
+func (a *app) GetDevice(table, id string) (*Device, bool) {
+	if a.cli == nil {
+		return nil, false
+	}
+	return a.cli.registry.get(table, id)
+}
+
+// ListDevices 驱动配置中的全部设备
+func (a *app) ListDevices() []*Device {
+	if a.cli == nil {
+		return []*Device{}
+	}
+	return a.cli.registry.all()
+}
+
+// GetTag 查询设备的数据点,设备数据点覆盖同标识的模型数据点
+func (a *app) GetTag(table, id, tagId string) (entity.Tag, bool) {
+	if a.cli == nil {
+		return entity.Tag{}, false
+	}
+	return a.cli.registry.tag(table, id, tagId)
+}
+
+// Schedule 注册轮询采集,驱动启动成功后按配置中的设备开始采集,收到新的启动配置时自动重启
+func (a *app) Schedule(cfg SchedulerConfig, poll PollFunc) {
+	s := NewScheduler(cfg, poll)
+	s.report = func(device *Device, err error) {
+		if err != nil {
+			a.errorStats.add(device.Table, device.ID, ClassifyError(err))
+		}
+		if Cfg.Status.Enable && a.status != nil {
+			a.status.observe(device.Table, device.ID, err)
+		}
+	}
+	a.schedLock.Lock()
+	old := a.scheduler
+	a.scheduler = s
+	a.schedLock.Unlock()
+	if old != nil {
+		old.Stop()
+	}
+}
+
+// startSchedule 按当前设备配置启动轮询采集
+func (a *app) startSchedule() {
+	a.schedLock.Lock()
+	s := a.scheduler
+	a.schedLock.Unlock()
+	if s == nil || a.cli == nil {
+		return
+	}
+	if err := s.Start(a.cli.registry.all()); err != nil {
+		logger.Errorf("轮询采集: 启动错误: %v", err)
+	}
+}
+
+// stopSchedule 停止轮询采集
+func (a *app) stopSchedule() {
+	a.schedLock.Lock()
+	s := a.scheduler
+	a.schedLock.Unlock()
+	if s != nil {
+		s.Stop()
+	}
+}
+
 func (a *app) RunLog(ctx context.Context, l entity.Log) error {
 	return a.cli.RunLog(ctx, l)
 }
@@ -2,16 +2,21 @@ package driver
 
 import (
 	"context"
-	"github.com/air-iot/sdk-go/v4/driver/entity"
-	"github.com/spf13/viper"
 	"testing"
+
+	"github.com/air-iot/sdk-go/v4/conn/mq"
+	"github.com/air-iot/sdk-go/v4/driver/entity"
 )
 
 func TestApp_WritePoints(t *testing.T) {
-	viper.Set("env", "dev")
-	viper.Set("mqtt.host", "127.0.0.1")
-	viper.Set("driver.id", "test")
-	viper.Set("driver.name", "test")
+	t.Setenv("SERVICEID", "test")
+	t.Setenv("DRIVER_ID", "test")
+	t.Setenv("DRIVER_NAME", "test")
+	t.Setenv("MQ_TYPE", mq.Memory)
+	cfg, err := LoadConfig(WithConfigOptional())
+	if err != nil {
+		t.Fatal(err)
+	}
 	var minValue float64 = 10
 	var MaxValue float64 = 100
 	//var MinRaw float64 = 200
@@ -19,7 +24,8 @@ func TestApp_WritePoints(t *testing.T) {
 	var Fixed int32 = 2
 	var Mod float64 = 2
 	point := entity.Point{
-		ID: "b1",
+		Table: "t1",
+		ID:    "b1",
 		Fields: []entity.Field{
 			{Tag: entity.Tag{
 				ID:       "p1",
@@ -44,15 +50,26 @@ func TestApp_WritePoints(t *testing.T) {
 		},
 	}
 
-	a := NewApp()
-	err := a.WritePoints(context.Background(), point)
+	a, err := NewAppWithConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = a.WritePoints(context.Background(), point)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
 
 func Test_wp(t *testing.T) {
-	a := new(app)
+	memory, _, err := mq.NewMemoryClient(mq.MemoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, err := mq.NewCodec(mq.CodecJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &app{mq: memory, codec: codec}
 	var minValue float64 = 10
 	var MaxValue float64 = 100
 	//var MinRaw float64 = 200
@@ -84,8 +101,25 @@ func Test_wp(t *testing.T) {
 			},
 		},
 	}
-	err := a.writePoints(context.Background(), "test", point)
+	err = a.writePoints(context.Background(), "test", point)
 	if err != nil {
 		t.Fatal(err)
 	}
 }
+
+func TestNewAppWithConfig_Invalid(t *testing.T) {
+	if _, err := NewAppWithConfig(new(Config)); err == nil {
+		t.Fatal("服务id为空时应返回错误")
+	}
+	cfg := &Config{ServiceID: "s1"}
+	cfg.Driver.ID, cfg.Driver.Name = "d1", "n1"
+	cfg.MQ.Codec = "xml"
+	if _, err := NewAppWithConfig(cfg); err == nil {
+		t.Fatal("未知消息编码时应返回错误")
+	}
+	cfg.MQ.Type = mq.Memory
+	cfg.MQ.Codec = mq.CodecMsgpack
+	if _, err := NewAppWithConfig(cfg); err == nil {
+		t.Fatal("消息队列不支持元数据时使用msgpack编码应返回错误")
+	}
+}
@@ -0,0 +1,349 @@
+package buffer
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/air-iot/json"
+	"github.com/air-iot/logger"
+)
+
+const segmentExt = ".seg"
+
+// Config 离线缓存配置
+type Config struct {
+	Enable        bool          `json:"enable" yaml:"enable"`
+	Dir           string        `json:"dir" yaml:"dir"`                     // 缓存目录
+	MaxSize       int64         `json:"maxSize" yaml:"maxSize"`             // 缓存总大小上限(字节),超出后丢弃最早的分段
+	MaxAge        time.Duration `json:"maxAge" yaml:"maxAge"`               // 缓存数据最长保留时间
+	SegmentSize   int64         `json:"segmentSize" yaml:"segmentSize"`     // 单个分段文件大小(字节)
+	RetryInterval time.Duration `json:"retryInterval" yaml:"retryInterval"` // 定时补发间隔
+}
+
+// Record 缓存的消息
+type Record struct {
+	Topic   []string `json:"topic"`
+	Payload []byte   `json:"payload"`
+	Time    int64    `json:"time"` // 写入缓存时间 毫秒数
+}
+
+// PublishFunc 补发消息
+type PublishFunc func(ctx context.Context, topic []string, payload []byte) error
+
+type segment struct {
+	seq  uint64
+	path string
+	size int64
+	time time.Time // 最后写入时间
+}
+
+// Buffer 磁盘存储的先进先出消息缓存,分段文件保存在配置的目录下
+type Buffer struct {
+	lock       sync.Mutex
+	replayLock sync.Mutex
+	cfg        Config
+	segments   []*segment
+	cur        *os.File
+	seq        uint64
+}
+
+// New 创建离线缓存,加载目录中已存在的分段
+func New(cfg Config) (*Buffer, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("缓存目录为空")
+	}
+	if cfg.SegmentSize <= 0 {
+		cfg.SegmentSize = 4 * 1024 * 1024
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, fmt.Errorf("创建缓存目录错误: %w", err)
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("读取缓存目录错误: %w", err)
+	}
+	b := &Buffer{cfg: cfg, segments: make([]*segment, 0)}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(e.Name(), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("读取缓存分段错误: %w", err)
+		}
+		if info.Size() == 0 {
+			_ = os.Remove(filepath.Join(cfg.Dir, e.Name()))
+			continue
+		}
+		b.segments = append(b.segments, &segment{seq: seq, path: filepath.Join(cfg.Dir, e.Name()), size: info.Size(), time: info.ModTime()})
+		if seq >= b.seq {
+			b.seq = seq + 1
+		}
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i].seq < b.segments[j].seq })
+	return b, nil
+}
+
+// Push 写入一条消息到缓存末尾
+func (b *Buffer) Push(topic []string, payload []byte) error {
+	bts, err := json.Marshal(&Record{Topic: topic, Payload: payload, Time: time.Now().Local().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 4+len(bts))
+	binary.BigEndian.PutUint32(frame, uint32(len(bts)))
+	copy(frame[4:], bts)
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var seg *segment
+	if b.cur != nil && len(b.segments) > 0 {
+		seg = b.segments[len(b.segments)-1]
+	}
+	if seg == nil || seg.size >= b.cfg.SegmentSize {
+		if seg, err = b.rotate(); err != nil {
+			return err
+		}
+	}
+	if _, err := b.cur.Write(frame); err != nil {
+		return fmt.Errorf("写入缓存错误: %w", err)
+	}
+	if err := b.cur.Sync(); err != nil {
+		return fmt.Errorf("写入缓存错误: %w", err)
+	}
+	seg.size += int64(len(frame))
+	seg.time = time.Now().Local()
+	b.trim()
+	return nil
+}
+
+// Empty 缓存是否为空
+func (b *Buffer) Empty() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.segments) == 0
+}
+
+// Size 缓存占用的字节数
+func (b *Buffer) Size() int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	var size int64
+	for _, seg := range b.segments {
+		size += seg.size
+	}
+	return size
+}
+
+// Replay 按写入顺序补发缓存中的消息,发送成功的消息从缓存中删除.
+// 发送失败时停止补发并保留剩余消息,同一时间只有一个补发在执行
+func (b *Buffer) Replay(ctx context.Context, publish PublishFunc) error {
+	if !b.replayLock.TryLock() {
+		return nil
+	}
+	defer b.replayLock.Unlock()
+	for {
+		segs := b.seal()
+		if len(segs) == 0 {
+			return nil
+		}
+		for _, seg := range segs {
+			if err := b.replaySegment(ctx, seg, publish); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Close 关闭缓存
+func (b *Buffer) Close() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.cur == nil {
+		return nil
+	}
+	err := b.cur.Close()
+	b.cur = nil
+	return err
+}
+
+func (b *Buffer) replaySegment(ctx context.Context, seg *segment, publish PublishFunc) error {
+	records, err := readSegment(seg.path)
+	if err != nil {
+		logger.Errorf("离线缓存: 分段=%s. 读取分段错误,丢弃该分段: %v", seg.path, err)
+	}
+	var expired time.Time
+	if b.cfg.MaxAge > 0 {
+		expired = time.Now().Local().Add(-b.cfg.MaxAge)
+	}
+	for i, r := range records {
+		if !expired.IsZero() && time.UnixMilli(r.Time).Before(expired) {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return b.rewrite(seg, records[i:], err)
+		}
+		if err := publish(ctx, r.Topic, r.Payload); err != nil {
+			return b.rewrite(seg, records[i:], err)
+		}
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.remove(seg)
+	return nil
+}
+
+// rewrite 补发失败时将未发送的消息写回分段
+func (b *Buffer) rewrite(seg *segment, records []*Record, cause error) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !b.contains(seg) {
+		return cause
+	}
+	tmp := seg.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("%w; 写回缓存错误: %v", cause, err)
+	}
+	w := bufio.NewWriter(f)
+	var size int64
+	for _, r := range records {
+		bts, err := json.Marshal(r)
+		if err != nil {
+			continue
+		}
+		var l [4]byte
+		binary.BigEndian.PutUint32(l[:], uint32(len(bts)))
+		_, _ = w.Write(l[:])
+		_, _ = w.Write(bts)
+		size += int64(4 + len(bts))
+	}
+	if err := w.Flush(); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("%w; 写回缓存错误: %v", cause, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("%w; 写回缓存错误: %v", cause, err)
+	}
+	if err := os.Rename(tmp, seg.path); err != nil {
+		return fmt.Errorf("%w; 写回缓存错误: %v", cause, err)
+	}
+	seg.size = size
+	return cause
+}
+
+// seal 关闭当前写入的分段,返回所有待补发的分段
+func (b *Buffer) seal() []*segment {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.cur != nil {
+		if err := b.cur.Close(); err != nil {
+			logger.Errorf("离线缓存: 关闭分段错误: %v", err)
+		}
+		b.cur = nil
+	}
+	segs := make([]*segment, len(b.segments))
+	copy(segs, b.segments)
+	return segs
+}
+
+func (b *Buffer) rotate() (*segment, error) {
+	if b.cur != nil {
+		if err := b.cur.Close(); err != nil {
+			logger.Errorf("离线缓存: 关闭分段错误: %v", err)
+		}
+		b.cur = nil
+	}
+	seg := &segment{seq: b.seq, path: filepath.Join(b.cfg.Dir, fmt.Sprintf("%020d%s", b.seq, segmentExt)), time: time.Now().Local()}
+	f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("创建缓存分段错误: %w", err)
+	}
+	b.seq++
+	b.cur = f
+	b.segments = append(b.segments, seg)
+	return seg, nil
+}
+
+// trim 超出大小或保留时间时丢弃最早的分段,正在写入的分段不丢弃
+func (b *Buffer) trim() {
+	var total int64
+	for _, seg := range b.segments {
+		total += seg.size
+	}
+	for len(b.segments) > 1 {
+		seg := b.segments[0]
+		overSize := b.cfg.MaxSize > 0 && total > b.cfg.MaxSize
+		overAge := b.cfg.MaxAge > 0 && time.Since(seg.time) > b.cfg.MaxAge
+		if !overSize && !overAge {
+			return
+		}
+		logger.Warnf("离线缓存: 分段=%s,大小=%d. 超出缓存限制,丢弃该分段", seg.path, seg.size)
+		total -= seg.size
+		b.remove(seg)
+	}
+}
+
+func (b *Buffer) contains(seg *segment) bool {
+	for _, s := range b.segments {
+		if s == seg {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Buffer) remove(seg *segment) {
+	for i, s := range b.segments {
+		if s == seg {
+			b.segments = append(b.segments[:i], b.segments[i+1:]...)
+			break
+		}
+	}
+	if err := os.Remove(seg.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		logger.Errorf("离线缓存: 删除分段=%s错误: %v", seg.path, err)
+	}
+}
+
+func readSegment(path string) ([]*Record, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	records := make([]*Record, 0)
+	var l [4]byte
+	for {
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			return records, err
+		}
+		bts := make([]byte, binary.BigEndian.Uint32(l[:]))
+		if _, err := io.ReadFull(r, bts); err != nil {
+			return records, err
+		}
+		record := new(Record)
+		if err := json.Unmarshal(bts, record); err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,115 @@
+package buffer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBuffer_Replay(t *testing.T) {
+	dir := t.TempDir()
+	b, err := New(Config{Dir: dir, SegmentSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := b.Push([]string{"data", "p", "t", "d"}, []byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if b.Empty() {
+		t.Fatal("缓存不应为空")
+	}
+
+	// 第5条发送失败,剩余数据保留
+	sent := make([]string, 0)
+	failed := errors.New("publish error")
+	err = b.Replay(context.Background(), func(ctx context.Context, topic []string, payload []byte) error {
+		if string(payload) == "5" {
+			return failed
+		}
+		sent = append(sent, string(payload))
+		return nil
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("补发错误不匹配: %v", err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新加载后继续补发
+	b, err = New(Config{Dir: dir, SegmentSize: 128})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Replay(context.Background(), func(ctx context.Context, topic []string, payload []byte) error {
+		sent = append(sent, string(payload))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 10 {
+		t.Fatalf("补发数量不匹配,应为=10,实际为=%d", len(sent))
+	}
+	for i, v := range sent {
+		if v != fmt.Sprintf("%d", i) {
+			t.Fatalf("补发顺序不匹配: %v", sent)
+		}
+	}
+	if !b.Empty() {
+		t.Fatal("补发后缓存应为空")
+	}
+}
+
+func TestBuffer_MaxSize(t *testing.T) {
+	b, err := New(Config{Dir: t.TempDir(), SegmentSize: 64, MaxSize: 256})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	for i := 0; i < 100; i++ {
+		if err := b.Push([]string{"data"}, []byte(fmt.Sprintf("%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := b.Size(); size > 256+64*2 {
+		t.Fatalf("缓存大小超出限制: %d", size)
+	}
+	var first string
+	if err := b.Replay(context.Background(), func(ctx context.Context, topic []string, payload []byte) error {
+		if first == "" {
+			first = string(payload)
+		}
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if first == "0" {
+		t.Fatal("最早的数据应被丢弃")
+	}
+}
+
+func TestBuffer_MaxAge(t *testing.T) {
+	b, err := New(Config{Dir: t.TempDir(), MaxAge: time.Millisecond * 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Push([]string{"data"}, []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(time.Millisecond * 20)
+	n := 0
+	if err := b.Replay(context.Background(), func(ctx context.Context, topic []string, payload []byte) error {
+		n++
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if n != 0 {
+		t.Fatalf("过期数据不应补发,实际补发=%d", n)
+	}
+}
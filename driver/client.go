@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,11 +13,11 @@ import (
 	"github.com/air-iot/json"
 	"github.com/air-iot/sdk-go/v4/driver/entity"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/air-iot/api-client-go/v4/driver"
 	"github.com/air-iot/logger"
 	dGrpc "github.com/air-iot/sdk-go/v4/driver/grpc"
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
 )
 
 type Client struct {
@@ -29,7 +30,9 @@ type Client struct {
 	clean          func()
 	cacheConfig    sync.Map
 	cacheConfigNum sync.Map
+	registry       registry
 	streamCount    int32
+	metrics        *driverMetrics
 }
 
 const totalStream = 7
@@ -52,12 +55,15 @@ func (c *Client) start() {
 		cancel()
 	}
 	go func() {
-		for {
+		for first := true; ; first = false {
 			select {
 			case <-ctx.Done():
 				return
 			default:
 				waitTime := Cfg.DriverGrpc.WaitTime
+				if !first {
+					c.metrics.reconnect()
+				}
 				if err := c.run(ctx); err != nil {
 					logger.WithContext(ctx).Errorln(err)
 				}
@@ -108,11 +114,17 @@ func (c *Client) connDriver(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, Cfg.DriverGrpc.Timeout)
 	defer cancel()
 	logger.WithContext(ctx).Infof("连接driver: 配置=%+v", Cfg.DriverGrpc)
+	opts, err := grpcx.DialOptions(Cfg.DriverGrpc.TLS, Cfg.DriverGrpc.Token)
+	if err != nil {
+		return fmt.Errorf("grpc证书配置错误: %w", err)
+	}
 	conn, err := grpc.DialContext(
 		ctx,
 		fmt.Sprintf("%s:%d", Cfg.DriverGrpc.Host, Cfg.DriverGrpc.Port),
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-		grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(Cfg.DriverGrpc.Limit*1024*1024), grpc.MaxCallSendMsgSize(Cfg.DriverGrpc.Limit*1024*1024)),
+		append(opts,
+			grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(Cfg.DriverGrpc.Limit*1024*1024), grpc.MaxCallSendMsgSize(Cfg.DriverGrpc.Limit*1024*1024)),
+			grpc.WithChainUnaryInterceptor(tracingUnaryInterceptor),
+		)...,
 	)
 	if err != nil {
 		return fmt.Errorf("grpc.Dial error: %w", err)
@@ -143,6 +155,7 @@ func (c *Client) healthCheck(ctx context.Context) {
 			for retry >= 0 {
 				healthRes, err := c.healthRequest(ctx)
 				if err != nil {
+					c.metrics.healthCheck("error")
 					errCtx := logger.NewErrorContext(ctx1, err)
 					logger.WithContext(errCtx).Errorf("健康检查: 健康检查第 %d 次错误", Cfg.DriverGrpc.Health.Retry-retry+1)
 					state = true
@@ -150,6 +163,7 @@ func (c *Client) healthCheck(ctx context.Context) {
 				} else {
 					state = false
 					if healthRes.GetStatus() == pb.HealthCheckResponse_SERVING {
+						c.metrics.healthCheck("serving")
 						newLogger.Debugf("健康检查: 正常")
 						if healthRes.Errors != nil && len(healthRes.Errors) > 0 {
 							for _, e := range healthRes.Errors {
@@ -157,6 +171,7 @@ func (c *Client) healthCheck(ctx context.Context) {
 							}
 						}
 					} else if healthRes.GetStatus() == pb.HealthCheckResponse_SERVICE_UNKNOWN {
+						c.metrics.healthCheck("unknown")
 						newLogger.Errorf("健康检查: 服务端未找到本驱动服务")
 						state = true
 					}
@@ -170,6 +185,7 @@ func (c *Client) healthCheck(ctx context.Context) {
 			} else if time.Now().Local().After(nextTime) {
 				nextTime = time.Now().Local().Add(time.Duration(Cfg.DriverGrpc.Health.Retry) * waitTime)
 				getV := atomic.LoadInt32(&c.streamCount)
+				c.metrics.setStreams(getV)
 				newLogger.Debugf("健康检查: 找到流数量=%d", getV)
 				if getV < totalStream {
 					newLogger.Errorf("健康检查: 找到流数量不匹配,应为=%d,实际为=%d", totalStream, getV)
@@ -506,7 +522,21 @@ func (c *Client) StartStream(ctx context.Context) error {
 		ctx1 := logger.NewModuleContext(context.Background(), entity.MODULE_START)
 		logger.WithContext(ctx1).Debugf("start: 接收到开始请求")
 		var cfg entity.Instance
-		if err := json.Unmarshal(res.Config, &cfg); err != nil {
+		model, err := DecodeStartConfig[map[string]interface{}, map[string]interface{}, map[string]interface{}](res.Config)
+		if err == nil {
+			err = json.Unmarshal(res.Config, &cfg)
+		}
+		if err != nil {
+			// 配置错误时清空设备模型并停止调度,不再使用上一次启动的设备
+			errCtx := logger.NewErrorContext(ctx1, err)
+			logger.WithContext(errCtx).Errorf("start: 解析配置错误")
+			c.registry.clear()
+			if a, _ := c.app.(*app); a != nil {
+				a.stopSchedule()
+				if a.status != nil {
+					a.status.reset(nil)
+				}
+			}
 			startRes := new(entity.GrpcResult)
 			startRes.Error = err.Error()
 			startRes.Code = 400
@@ -520,6 +550,7 @@ func (c *Client) StartStream(ctx context.Context) error {
 			}
 			continue
 		}
+		c.registry.load(model)
 		if cfg.Debug != nil {
 			if *cfg.Debug {
 				logger.SetLevel(logger.DebugLevel)
@@ -581,12 +612,22 @@ func (c *Client) StartStream(ctx context.Context) error {
 					}
 				}
 			}()
+			a, _ := c.app.(*app)
+			if a != nil {
+				a.stopSchedule()
+				if a.status != nil {
+					a.status.reset(c.registry.all())
+				}
+			}
 			startRes := new(entity.GrpcResult)
 			if err := c.driver.Start(newCtx, c.app, res.Config); err != nil {
 				startRes.Error = err.Error()
 				startRes.Code = 400
 			} else {
 				startRes.Code = 200
+				if a != nil {
+					a.startSchedule()
+				}
 			}
 			bts, _ := json.Marshal(startRes)
 			if err := stream.Send(&pb.StartResult{
@@ -619,6 +660,8 @@ func (c *Client) RunStream(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		// 驱动管理通过stream的header传递链路信息,收到消息后header已到达
+		md, _ := stream.Header()
 		go func(res *pb.RunRequest) {
 			newCtx, cancel := context.WithTimeout(context.Background(), Cfg.DriverGrpc.Timeout)
 			defer cancel()
@@ -652,12 +695,15 @@ func (c *Client) RunStream(ctx context.Context) error {
 				}
 			}()
 			gr := new(entity.GrpcResult)
+			defer c.metrics.observeCommand("run", res.TableId, time.Now())
+			newCtx, span := startCommandSpan(newCtx, md, "driver.Run", res.TableId, res.Id, res.SerialNo)
 			runRes, err := c.driver.Run(newCtx, c.app, &entity.Command{
 				Table:    res.TableId,
 				Id:       res.Id,
 				SerialNo: res.SerialNo,
 				Command:  res.Command,
 			})
+			endSpan(span, err)
 			if err != nil {
 				gr.Error = err.Error()
 				gr.Code = 400
@@ -696,6 +742,8 @@ func (c *Client) WriteTagStream(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		// 驱动管理通过stream的header传递链路信息,收到消息后header已到达
+		md, _ := stream.Header()
 		go func(res *pb.RunRequest) {
 			newCtx, cancel := context.WithTimeout(context.Background(), Cfg.DriverGrpc.Timeout)
 			defer cancel()
@@ -729,12 +777,15 @@ func (c *Client) WriteTagStream(ctx context.Context) error {
 				}
 			}()
 			gr := new(entity.GrpcResult)
+			defer c.metrics.observeCommand("writeTag", res.TableId, time.Now())
+			newCtx, span := startCommandSpan(newCtx, md, "driver.WriteTag", res.TableId, res.Id, res.SerialNo)
 			runRes, err := c.driver.WriteTag(newCtx, c.app, &entity.Command{
 				Table:    res.TableId,
 				Id:       res.Id,
 				SerialNo: res.SerialNo,
 				Command:  res.Command,
 			})
+			endSpan(span, err)
 			if err != nil {
 				gr.Error = err.Error()
 				gr.Code = 400
@@ -773,6 +824,8 @@ func (c *Client) BatchRunStream(ctx context.Context) error {
 		if err != nil {
 			return err
 		}
+		// 驱动管理通过stream的header传递链路信息,收到消息后header已到达
+		md, _ := stream.Header()
 		go func(res *pb.BatchRunRequest) {
 			newCtx, cancel := context.WithTimeout(context.Background(), Cfg.DriverGrpc.Timeout)
 			defer cancel()
@@ -807,12 +860,15 @@ func (c *Client) BatchRunStream(ctx context.Context) error {
 				}
 			}()
 			gr := new(entity.GrpcResult)
+			defer c.metrics.observeCommand("batchRun", res.TableId, time.Now())
+			newCtx, span := startCommandSpan(newCtx, md, "driver.BatchRun", res.TableId, strings.Join(res.Id, ","), res.SerialNo)
 			runRes, err := c.driver.BatchRun(newCtx, c.app, &entity.BatchCommand{
 				Table:    res.TableId,
 				Ids:      res.Id,
 				SerialNo: res.SerialNo,
 				Command:  res.Command,
 			})
+			endSpan(span, err)
 			if err != nil {
 				gr.Error = err.Error()
 				gr.Code = 400
@@ -884,6 +940,7 @@ func (c *Client) DebugStream(ctx context.Context) error {
 					}
 				}
 			}()
+			defer c.metrics.observeCommand("debug", "", time.Now())
 			runRes, err := c.driver.Debug(newCtx, c.app, res.Data)
 			gr := new(entity.GrpcResult)
 			if err != nil {
@@ -957,6 +1014,7 @@ func (c *Client) HttpProxyStream(ctx context.Context) error {
 					}
 				}
 			}()
+			defer c.metrics.observeCommand("httpProxy", "", time.Now())
 			gr := new(entity.GrpcResult)
 			if res.GetHeaders() != nil {
 				if err := json.Unmarshal(res.GetHeaders(), &header); err != nil {
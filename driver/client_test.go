@@ -0,0 +1,136 @@
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	pb "github.com/air-iot/api-client-go/v4/driver"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+type fakeDriverServiceClient struct {
+	pb.DriverServiceClient
+	start *fakeStartStream
+	run   *fakeRunStream
+}
+
+func (c *fakeDriverServiceClient) StartStream(context.Context, ...grpc.CallOption) (pb.DriverService_StartStreamClient, error) {
+	return c.start, nil
+}
+
+func (c *fakeDriverServiceClient) RunStream(context.Context, ...grpc.CallOption) (pb.DriverService_RunStreamClient, error) {
+	return c.run, nil
+}
+
+type fakeStartStream struct {
+	grpc.ClientStream
+	requests chan *pb.StartRequest
+	results  chan *pb.StartResult
+}
+
+func (s *fakeStartStream) Recv() (*pb.StartRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *fakeStartStream) Send(res *pb.StartResult) error {
+	s.results <- res
+	return nil
+}
+
+func (s *fakeStartStream) CloseSend() error { return nil }
+
+// fakeRunStream 驱动管理下发指令的stream,header中携带链路信息
+type fakeRunStream struct {
+	grpc.ClientStream
+	header   metadata.MD
+	requests chan *pb.RunRequest
+	results  chan *pb.RunResult
+}
+
+func (s *fakeRunStream) Header() (metadata.MD, error) { return s.header, nil }
+
+func (s *fakeRunStream) Recv() (*pb.RunRequest, error) {
+	req, ok := <-s.requests
+	if !ok {
+		return nil, io.EOF
+	}
+	return req, nil
+}
+
+func (s *fakeRunStream) Send(res *pb.RunResult) error {
+	s.results <- res
+	return nil
+}
+
+func (s *fakeRunStream) CloseSend() error { return nil }
+
+type recordingDriver struct {
+	Driver
+	starts chan []byte
+	runs   chan trace.SpanContext
+}
+
+func (d *recordingDriver) Start(_ context.Context, _ App, driverConfig []byte) error {
+	d.starts <- driverConfig
+	return nil
+}
+
+func (d *recordingDriver) Run(ctx context.Context, _ App, _ *entity.Command) (interface{}, error) {
+	d.runs <- trace.SpanFromContext(ctx).SpanContext()
+	return nil, nil
+}
+
+func TestClient_StartStream_InvalidConfig(t *testing.T) {
+	stream := &fakeStartStream{requests: make(chan *pb.StartRequest, 2), results: make(chan *pb.StartResult, 2)}
+	d := &recordingDriver{starts: make(chan []byte, 2)}
+	c := &Client{cli: &fakeDriverServiceClient{start: stream}, driver: d}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.StartStream(context.Background())
+	}()
+
+	result := func() entity.GrpcResult {
+		var res entity.GrpcResult
+		select {
+		case r := <-stream.results:
+			if err := json.Unmarshal(r.Message, &res); err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("未收到启动结果")
+		}
+		return res
+	}
+	stream.requests <- &pb.StartRequest{Request: "1", Config: []byte(`{"tables":[{"id":"t1","devices":[{"id":"d1"}]}]}`)}
+	if res := result(); res.Code != 200 {
+		t.Fatalf("启动结果应为=200,实际为=%d: %s", res.Code, res.Error)
+	}
+	if _, ok := c.registry.get("t1", "d1"); !ok {
+		t.Fatal("启动后应加载设备模型")
+	}
+
+	// 设备id重复,配置校验失败
+	stream.requests <- &pb.StartRequest{Request: "2", Config: []byte(`{"tables":[{"id":"t1","devices":[{"id":"d1"},{"id":"d1"}]}]}`)}
+	if res := result(); res.Code != 400 || res.Error == "" {
+		t.Fatalf("配置错误时启动结果应为=400,实际为=%d", res.Code)
+	}
+	if devices := c.registry.all(); len(devices) != 0 {
+		t.Errorf("配置错误时应清空设备模型,实际为=%d个设备", len(devices))
+	}
+	close(stream.requests)
+	<-done
+	if len(d.starts) != 1 {
+		t.Errorf("配置错误时不应启动驱动,启动次数=%d", len(d.starts))
+	}
+}
@@ -1,12 +1,20 @@
 package driver
 
 import (
+	"errors"
+	"fmt"
+	"strings"
+
 	"github.com/air-iot/logger"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+
 	"github.com/air-iot/sdk-go/v4/conn/mq"
+	"github.com/air-iot/sdk-go/v4/driver/buffer"
 	"github.com/air-iot/sdk-go/v4/driver/grpc"
 )
 
-// Cfg 全局配置(需要先执行MustLoad，否则拿不到配置)
+// Cfg 全局配置(由NewApp或NewAppWithConfig设置),每个进程只支持一个App
 var Cfg = new(Config)
 
 type Config struct {
@@ -17,12 +25,165 @@ type Config struct {
 		ID   string `json:"id" yaml:"id"`
 		Name string `json:"name" yaml:"name"`
 	} `json:"driver" yaml:"driver"`
-	DriverGrpc grpc.Config   `json:"driverGrpc" yaml:"driverGrpc"`
-	Log        logger.Config `json:"log" yaml:"log"`
-	MQ         mq.Config     `json:"mq" yaml:"mq"`
+	DriverGrpc grpc.Config    `json:"driverGrpc" yaml:"driverGrpc"`
+	Log        logger.Config  `json:"log" yaml:"log"`
+	MQ         mq.Config      `json:"mq" yaml:"mq"`
+	Buffer     buffer.Config  `json:"buffer" yaml:"buffer"`
+	Pipeline   PipelineConfig `json:"pipeline" yaml:"pipeline"`
+	Status     StatusConfig   `json:"status" yaml:"status"`
+	Tracing    TracingConfig  `json:"tracing" yaml:"tracing"`
 	Pprof      struct {
 		Enable bool   `json:"enable" yaml:"enable"`
 		Host   string `json:"host" yaml:"host"`
 		Port   string `json:"port" yaml:"port"`
 	} `json:"pprof" yaml:"pprof"`
 }
+
+type loadOptions struct {
+	configPath     string
+	configName     string
+	configOptional bool
+	envPrefix      string
+	flagSet        *pflag.FlagSet
+	args           []string
+}
+
+// LoadOption 读取配置的可选参数
+type LoadOption func(*loadOptions)
+
+// WithConfigPath 配置文件目录,默认./etc/
+func WithConfigPath(path string) LoadOption {
+	return func(o *loadOptions) {
+		o.configPath = path
+	}
+}
+
+// WithConfigName 配置文件名(不含扩展名),默认config
+func WithConfigName(name string) LoadOption {
+	return func(o *loadOptions) {
+		o.configName = name
+	}
+}
+
+// WithConfigOptional 配置文件不存在时不返回错误,只使用默认值、环境变量和命令行参数
+func WithConfigOptional() LoadOption {
+	return func(o *loadOptions) {
+		o.configOptional = true
+	}
+}
+
+// WithEnvPrefix 环境变量前缀,如前缀为DRIVER时读取DRIVER_MQ_TYPE
+func WithEnvPrefix(prefix string) LoadOption {
+	return func(o *loadOptions) {
+		o.envPrefix = prefix
+	}
+}
+
+// WithFlagSet 从命令行参数读取配置,在fs上注册project、serviceId、groupId和config参数并解析args
+func WithFlagSet(fs *pflag.FlagSet, args []string) LoadOption {
+	return func(o *loadOptions) {
+		o.flagSet = fs
+		o.args = args
+	}
+}
+
+// LoadConfig 读取配置,优先级为命令行参数、环境变量、配置文件、默认值
+func LoadConfig(opts ...LoadOption) (*Config, error) {
+	o := &loadOptions{configPath: "./etc/", configName: "config"}
+	for _, opt := range opts {
+		opt(o)
+	}
+	v := viper.New()
+	setDefaults(v)
+	if o.flagSet != nil {
+		if o.flagSet.Lookup("project") == nil {
+			o.flagSet.String("project", "default", "项目id")
+		}
+		if o.flagSet.Lookup("serviceId") == nil {
+			o.flagSet.String("serviceId", "", "服务id")
+		}
+		if o.flagSet.Lookup("groupId") == nil {
+			o.flagSet.String("groupId", "", "组id")
+		}
+		if o.flagSet.Lookup("config") == nil {
+			o.flagSet.String("config", o.configPath, "配置文件")
+		}
+		if !o.flagSet.Parsed() {
+			if err := o.flagSet.Parse(o.args); err != nil {
+				return nil, fmt.Errorf("读取命令行参数错误, %w", err)
+			}
+		}
+		if cfgPath, err := o.flagSet.GetString("config"); err == nil && cfgPath != "" {
+			o.configPath = cfgPath
+		}
+		if err := v.BindPFlags(o.flagSet); err != nil {
+			return nil, fmt.Errorf("读取命令行参数错误, %w", err)
+		}
+	}
+	if o.envPrefix != "" {
+		v.SetEnvPrefix(o.envPrefix)
+	}
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	v.SetConfigType("yaml")
+	v.SetConfigName(o.configName)
+	v.AddConfigPath(o.configPath)
+	if err := v.ReadInConfig(); err != nil {
+		var notFound viper.ConfigFileNotFoundError
+		if !o.configOptional || !errors.As(err, &notFound) {
+			return nil, fmt.Errorf("读取配置错误, %w", err)
+		}
+	}
+	cfg := new(Config)
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("配置解析错误, %w", err)
+	}
+	return cfg, nil
+}
+
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("project", "default")
+	v.SetDefault("serviceId", "")
+	v.SetDefault("groupId", "")
+	v.SetDefault("driver.id", "")
+	v.SetDefault("driver.name", "")
+	v.SetDefault("log.level", 4)
+	v.SetDefault("log.format", "json")
+	v.SetDefault("log.output", "stdout")
+	v.SetDefault("mq.type", "mqtt")
+	v.SetDefault("mq.timeout", "60s")
+	v.SetDefault("mq.mqtt.host", "mqtt")
+	v.SetDefault("mq.mqtt.port", 1883)
+	v.SetDefault("mq.mqtt.username", "admin")
+	v.SetDefault("mq.mqtt.password", "public")
+	v.SetDefault("mq.mqtt.keepAlive", 60)
+	v.SetDefault("mq.mqtt.connectTimeout", 20)
+	v.SetDefault("mq.mqtt.protocolVersion", 4)
+	v.SetDefault("mq.rabbit.host", "rabbit")
+	v.SetDefault("mq.rabbit.port", 5672)
+	v.SetDefault("mq.rabbit.username", "admin")
+	v.SetDefault("mq.rabbit.password", "public")
+	v.SetDefault("mq.kafka.brokers", []string{"kafka:9092"})
+	v.SetDefault("buffer.dir", "./data/buffer")
+	v.SetDefault("buffer.maxSize", 100*1024*1024)
+	v.SetDefault("buffer.maxAge", "72h")
+	v.SetDefault("buffer.segmentSize", 4*1024*1024)
+	v.SetDefault("buffer.retryInterval", "30s")
+	v.SetDefault("pipeline.queueSize", 10000)
+	v.SetDefault("pipeline.workers", 4)
+	v.SetDefault("pipeline.batchSize", 100)
+	v.SetDefault("pipeline.linger", "100ms")
+	v.SetDefault("pipeline.policy", string(PolicyBlock))
+	v.SetDefault("status.field", "status")
+	v.SetDefault("status.maxFailures", 3)
+	v.SetDefault("status.debounce", "5s")
+	v.SetDefault("tracing.exporter", "stdout")
+	v.SetDefault("tracing.sampleRatio", 1)
+	v.SetDefault("driverGrpc.host", "driver")
+	v.SetDefault("driverGrpc.port", 9224)
+	v.SetDefault("driverGrpc.health.requestTime", "10s")
+	v.SetDefault("driverGrpc.health.retry", 3)
+	v.SetDefault("driverGrpc.waitTime", "5s")
+	v.SetDefault("driverGrpc.timeout", "600s")
+	v.SetDefault("driverGrpc.limit", 100)
+}
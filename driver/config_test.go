@@ -0,0 +1,50 @@
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/pflag"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "driver.yaml"), []byte("serviceId: s1\ndriver:\n  id: d1\n  name: n1\nmq:\n  type: memory\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_MQ_TIMEOUT", "5s")
+	t.Setenv("TEST_TRACING_SAMPLERATIO", "0")
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	cfg, err := LoadConfig(
+		WithConfigPath(dir),
+		WithConfigName("driver"),
+		WithEnvPrefix("TEST"),
+		WithFlagSet(fs, []string{"--project", "p1"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.ServiceID != "s1" || cfg.Driver.ID != "d1" || cfg.Driver.Name != "n1" {
+		t.Fatalf("配置文件读取错误: %+v", cfg)
+	}
+	if cfg.Project != "p1" {
+		t.Fatalf("命令行参数读取错误,应为=p1,实际为=%s", cfg.Project)
+	}
+	if cfg.MQ.Timeout.String() != "5s" {
+		t.Fatalf("环境变量读取错误,应为=5s,实际为=%s", cfg.MQ.Timeout)
+	}
+	if cfg.Tracing.SampleRatio == nil || *cfg.Tracing.SampleRatio != 0 {
+		t.Fatalf("采样比例为0时应保留,实际为=%v", cfg.Tracing.SampleRatio)
+	}
+	if cfg.DriverGrpc.Port != 9224 {
+		t.Fatalf("默认值错误,应为=9224,实际为=%d", cfg.DriverGrpc.Port)
+	}
+
+	if _, err := LoadConfig(WithConfigPath(t.TempDir())); err == nil {
+		t.Fatal("配置文件不存在时应返回错误")
+	}
+	if _, err := LoadConfig(WithConfigPath(t.TempDir()), WithConfigOptional()); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -514,3 +514,37 @@ loop:
 
 	return
 }
+
+// RangeQuality 同Range,并根据有效范围的处理结果返回数据质量:
+// 替换为固定值、边界值或上次的值时为不确定,超出有效范围但保留原始值时为不确定
+func RangeQuality(tagRange *entity.Range, preVal, raw *decimal.Decimal) (newValue, rawValue *float64, invalidType string, isSave bool, quality entity.Quality, reason entity.QualityReason) {
+	newValue, rawValue, invalidType, isSave = Range(tagRange, preVal, raw)
+	if newValue == nil {
+		return
+	}
+	quality = entity.QualityGood
+	value, _ := raw.Float64()
+	if *newValue != value {
+		quality = entity.QualityUncertain
+		switch tagRange.Active {
+		case entity.Active_Fixed:
+			reason = entity.ReasonSubstituted
+		case entity.Active_Boundary:
+			reason = entity.ReasonClamped
+		case entity.Active_Latest:
+			reason = entity.ReasonLastKnown
+		default:
+			reason = entity.ReasonSubstituted
+		}
+		return
+	}
+	if invalidType != "" || rawValue != nil {
+		quality, reason = entity.QualityUncertain, entity.ReasonOutOfRange
+		return
+	}
+	if tagRange != nil && tagRange.MinValue != nil && tagRange.MaxValue != nil &&
+		(value < *tagRange.MinValue || value > *tagRange.MaxValue) {
+		quality, reason = entity.QualityUncertain, entity.ReasonOutOfRange
+	}
+	return
+}
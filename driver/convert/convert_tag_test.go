@@ -78,3 +78,28 @@ func Test_ConvertRange_3(t *testing.T) {
 		t.Log(*gotRawValue)
 	}
 }
+
+func Test_RangeQuality(t *testing.T) {
+	minValue, maxValue, fixedValue := 0.0, 10.0, 5.0
+	tests := []struct {
+		name    string
+		active  entity.Active
+		raw     float64
+		quality entity.Quality
+		reason  entity.QualityReason
+	}{
+		{"valid", entity.Active_Fixed, 8, entity.QualityGood, ""},
+		{"fixed", entity.Active_Fixed, 20, entity.QualityUncertain, entity.ReasonSubstituted},
+		{"boundary", entity.Active_Boundary, 20, entity.QualityUncertain, entity.ReasonClamped},
+		{"latest", entity.Active_Latest, 20, entity.QualityUncertain, entity.ReasonLastKnown},
+	}
+	preVal := decimal.NewFromInt(8)
+	for _, tt := range tests {
+		tagRange := entity.Range{MinValue: &minValue, MaxValue: &maxValue, Active: tt.active, FixedValue: &fixedValue}
+		raw := decimal.NewFromFloat(tt.raw)
+		_, _, _, _, quality, reason := RangeQuality(&tagRange, &preVal, &raw)
+		if quality != tt.quality || reason != tt.reason {
+			t.Fatalf("%s: 数据质量应为=%s/%s,实际为=%s/%s", tt.name, tt.quality, tt.reason, quality, reason)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package driver
+
+import (
+	"math"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// deadbandValue 数据点上次上报的值和时间
+type deadbandValue struct {
+	value    float64
+	unixTime int64
+}
+
+// deadband 判断数据点是否需要上报,需要上报时记录本次上报的值.
+// 未配置死区时全部上报;配置多个死区时变化量超过任一死区即上报;
+// 未配置绝对值和百分比死区时只有值变化才上报
+func (a *app) deadband(cacheKey string, d *entity.Deadband, val float64, unixTime int64) bool {
+	if d == nil {
+		return true
+	}
+	key := cacheKey + "__deadband"
+	report := true
+	if preI, ok := a.cacheValue.Load(key); ok {
+		pre := preI.(deadbandValue)
+		report = exceedDeadband(d, pre.value, val)
+		if !report && d.MaxSilence != nil && *d.MaxSilence > 0 &&
+			float64(unixTime-pre.unixTime) >= *d.MaxSilence*1000 {
+			report = true
+		}
+	}
+	if report {
+		a.cacheValue.Store(key, deadbandValue{value: val, unixTime: unixTime})
+	}
+	return report
+}
+
+func exceedDeadband(d *entity.Deadband, pre, val float64) bool {
+	delta := math.Abs(val - pre)
+	if d.Absolute == nil && d.Percent == nil {
+		return delta != 0
+	}
+	if d.Absolute != nil && delta > *d.Absolute {
+		return true
+	}
+	if d.Percent != nil {
+		if pre == 0 {
+			return delta != 0
+		}
+		if delta/math.Abs(pre)*100 > *d.Percent {
+			return true
+		}
+	}
+	return false
+}
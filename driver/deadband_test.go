@@ -0,0 +1,32 @@
+package driver
+
+import (
+	"testing"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+func TestApp_Deadband(t *testing.T) {
+	absolute, percent, maxSilence := 1.0, 10.0, 60.0
+	a := &app{}
+	tests := []struct {
+		name     string
+		deadband *entity.Deadband
+		values   []float64
+		times    []int64
+		reported []bool
+	}{
+		{"none", nil, []float64{1, 1}, []int64{0, 1}, []bool{true, true}},
+		{"unchanged", &entity.Deadband{}, []float64{1, 1, 2}, []int64{0, 1, 2}, []bool{true, false, true}},
+		{"absolute", &entity.Deadband{Absolute: &absolute}, []float64{10, 10.5, 11, 11.2}, []int64{0, 1, 2, 3}, []bool{true, false, false, true}},
+		{"percent", &entity.Deadband{Percent: &percent}, []float64{100, 105, 111, 95}, []int64{0, 1, 2, 3}, []bool{true, false, true, true}},
+		{"maxSilence", &entity.Deadband{Absolute: &absolute, MaxSilence: &maxSilence}, []float64{10, 10, 10, 10}, []int64{0, 59000, 60000, 61000}, []bool{true, false, true, false}},
+	}
+	for _, tt := range tests {
+		for i, v := range tt.values {
+			if got := a.deadband(tt.name, tt.deadband, v, tt.times[i]); got != tt.reported[i] {
+				t.Fatalf("%s: 第%d个值=%v,是否上报应为=%v,实际为=%v", tt.name, i, v, tt.reported[i], got)
+			}
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Package drivertest 驱动集成测试工具,在进程内启动驱动管理服务和消息队列,
+// 无需真实的驱动管理和MQTT即可测试driver.Driver的实现
+package drivertest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/air-iot/sdk-go/v4/driver"
+)
+
+// Harness 驱动测试环境
+type Harness struct {
+	Server *Server
+	MQ     *MQ
+	App    driver.App
+	Config *driver.Config
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// New 启动驱动管理服务和内存消息队列,运行驱动并等待驱动连接成功
+func New(ctx context.Context, drv driver.Driver) (*Harness, error) {
+	return NewWithConfig(ctx, drv, new(driver.Config))
+}
+
+// NewWithConfig 使用传入的驱动配置启动测试环境,驱动管理的连接配置会被替换为测试服务的地址
+func NewWithConfig(ctx context.Context, drv driver.Driver, cfg *driver.Config) (*Harness, error) {
+	srv, err := NewServer()
+	if err != nil {
+		return nil, err
+	}
+	setConfig(srv, cfg)
+	h := &Harness{Server: srv, MQ: NewMQ(), Config: cfg, done: make(chan struct{})}
+	if h.App, err = driver.NewAppWithConfig(cfg, driver.WithMQ(h.MQ)); err != nil {
+		srv.Close()
+		return nil, err
+	}
+	runCtx, cancel := context.WithCancel(context.Background())
+	h.cancel = cancel
+	go func() {
+		defer close(h.done)
+		_ = h.App.Run(runCtx, drv)
+	}()
+	if err := srv.WaitReady(ctx); err != nil {
+		h.Close()
+		return nil, err
+	}
+	return h, nil
+}
+
+// Close 停止驱动和驱动管理服务
+func (h *Harness) Close() {
+	h.cancel()
+	<-h.done
+	h.Server.Close()
+}
+
+func setConfig(srv *Server, cfg *driver.Config) {
+	cfg.DriverGrpc.Host, cfg.DriverGrpc.Port = srv.Addr()
+	if cfg.ServiceID == "" {
+		cfg.ServiceID = "drivertest"
+	}
+	if cfg.Project == "" {
+		cfg.Project = "default"
+	}
+	if cfg.Driver.ID == "" {
+		cfg.Driver.ID = "drivertest"
+	}
+	if cfg.Driver.Name == "" {
+		cfg.Driver.Name = fmt.Sprintf("%s-driver", cfg.Driver.ID)
+	}
+	if cfg.DriverGrpc.WaitTime <= 0 {
+		cfg.DriverGrpc.WaitTime = time.Millisecond * 100
+	}
+	if cfg.DriverGrpc.Timeout <= 0 {
+		cfg.DriverGrpc.Timeout = time.Second * 10
+	}
+	if cfg.DriverGrpc.Health.RequestTime <= 0 {
+		cfg.DriverGrpc.Health.RequestTime = time.Second
+	}
+	if cfg.DriverGrpc.Health.Retry <= 0 {
+		cfg.DriverGrpc.Health.Retry = 3
+	}
+	if cfg.DriverGrpc.Limit <= 0 {
+		cfg.DriverGrpc.Limit = 100
+	}
+	if cfg.MQ.Timeout <= 0 {
+		cfg.MQ.Timeout = time.Second * 10
+	}
+}
@@ -0,0 +1,145 @@
+package drivertest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/air-iot/sdk-go/v4/driver"
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+type testDriver struct{}
+
+func (d *testDriver) Schema(context.Context, driver.App) (string, error) {
+	return "{}", nil
+}
+
+func (d *testDriver) Start(ctx context.Context, a driver.App, _ []byte) error {
+	return a.WritePoints(ctx, entity.Point{Table: "t1", ID: "d1", Fields: []entity.Field{{Tag: entity.Tag{ID: "p1"}, Value: 1}}})
+}
+
+func (d *testDriver) Run(_ context.Context, _ driver.App, cmd *entity.Command) (interface{}, error) {
+	return cmd.Id, nil
+}
+
+func (d *testDriver) BatchRun(context.Context, driver.App, *entity.BatchCommand) (interface{}, error) {
+	return nil, nil
+}
+
+func (d *testDriver) WriteTag(context.Context, driver.App, *entity.Command) (interface{}, error) {
+	return nil, nil
+}
+
+func (d *testDriver) Debug(context.Context, driver.App, []byte) (interface{}, error) {
+	return nil, nil
+}
+
+func (d *testDriver) HttpProxy(context.Context, driver.App, string, http.Header, []byte) (interface{}, error) {
+	return nil, nil
+}
+
+func (d *testDriver) Stop(context.Context, driver.App) error {
+	return nil
+}
+
+func TestHarness(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	h, err := New(ctx, &testDriver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	if err := h.Server.Start(ctx, entity.Instance{ID: "test"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.MQ.Wait(ctx, 1, "data"); err != nil {
+		t.Fatal(err)
+	}
+	points, err := h.MQ.Points()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(points) != 1 || points[0].ID != "d1" {
+		t.Fatalf("数据点不匹配: %+v", points)
+	}
+	res, err := h.Server.Run(ctx, entity.Command{Table: "t1", Id: "d1", SerialNo: "1", Command: []byte("{}")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Result != "d1" {
+		t.Fatalf("指令结果不匹配: %+v", res.Result)
+	}
+}
+
+// testStream 测试用的stream,recv在首次调用时通知已连接
+type testStream struct {
+	started chan struct{}
+	recvCh  chan string
+	sendCh  chan string
+	done    chan error
+}
+
+func serveTestStream(s *session[string, string]) *testStream {
+	ts := &testStream{started: make(chan struct{}), recvCh: make(chan string), sendCh: make(chan string, 1), done: make(chan error, 1)}
+	var once sync.Once
+	go func() {
+		ts.done <- s.serve(context.Background(), func(req string) error {
+			ts.sendCh <- req
+			return nil
+		}, func() (string, error) {
+			once.Do(func() { close(ts.started) })
+			res, ok := <-ts.recvCh
+			if !ok {
+				return "", io.EOF
+			}
+			return res, nil
+		}, func(res string) string { return res })
+	}()
+	<-ts.started
+	return ts
+}
+
+func TestSession_Reconnect(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	s := newSession[string, string]()
+	old := serveTestStream(s)
+	// 旧stream退出前驱动重连
+	cur := serveTestStream(s)
+	close(old.recvCh)
+	<-old.done
+	select {
+	case <-s.ready():
+	default:
+		t.Fatal("旧stream退出后新的stream应保持连接")
+	}
+	go func() {
+		req := <-cur.sendCh
+		cur.recvCh <- req
+	}()
+	res, err := s.call(ctx, "1", "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != "1" {
+		t.Fatalf("结果应为=1,实际为=%s", res)
+	}
+	if len(old.sendCh) != 0 {
+		t.Error("重连后请求不应发送到旧stream")
+	}
+	close(cur.recvCh)
+	<-cur.done
+	select {
+	case <-s.ready():
+		t.Fatal("stream全部退出后不应处于连接状态")
+	default:
+	}
+	if _, err := s.call(ctx, "2", "2"); err == nil {
+		t.Fatal("stream未连接时请求应返回错误")
+	}
+}
@@ -0,0 +1,158 @@
+package drivertest
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/air-iot/json"
+
+	"github.com/air-iot/sdk-go/v4/conn/mq"
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+var _ mq.MQ = new(MQ)
+
+// Message 驱动发送到消息队列的消息
+type Message struct {
+	Topic   []string
+	Payload []byte
+	Header  mq.Header // 消息元数据,如数据点编码的content-type
+}
+
+// MQ 记录驱动发送消息的内存消息队列,订阅和分发使用mq的内存消息队列
+type MQ struct {
+	lock      sync.RWMutex
+	mem       mq.MQ
+	messages  []Message
+	callbacks []mq.Callback
+	notify    chan struct{}
+}
+
+// NewMQ 创建内存消息队列
+func NewMQ() *MQ {
+	mem, _, _ := mq.NewMemoryClient(mq.MemoryConfig{})
+	return &MQ{mem: mem, messages: make([]Message, 0), callbacks: make([]mq.Callback, 0), notify: make(chan struct{})}
+}
+
+func (m *MQ) Publish(ctx context.Context, topicParams []string, payload []byte) error {
+	return m.PublishWithHeader(ctx, topicParams, payload, nil)
+}
+
+func (m *MQ) PublishWithHeader(ctx context.Context, topicParams []string, payload []byte, header mq.Header) error {
+	m.lock.Lock()
+	m.messages = append(m.messages, Message{Topic: append([]string{}, topicParams...), Payload: append([]byte{}, payload...), Header: header})
+	close(m.notify)
+	m.notify = make(chan struct{})
+	m.lock.Unlock()
+	return m.mem.Publish(ctx, topicParams, payload)
+}
+
+func (m *MQ) Consume(ctx context.Context, topicParams []string, splitN int, handler mq.Handler) error {
+	return m.mem.Consume(ctx, topicParams, splitN, handler)
+}
+
+func (m *MQ) UnSubscription(ctx context.Context, topicParams []string) error {
+	return m.mem.UnSubscription(ctx, topicParams)
+}
+
+func (m *MQ) Subscriptions() []mq.Subscription {
+	return mq.Subscriptions(m.mem)
+}
+
+func (m *MQ) Callback(cb mq.Callback) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.callbacks = append(m.callbacks, cb)
+}
+
+// Connect 模拟消息队列重连,触发回调
+func (m *MQ) Connect() error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	for _, cb := range m.callbacks {
+		if err := cb.Connect(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Messages 返回topic以prefix开头的消息,prefix为空时返回全部消息
+func (m *MQ) Messages(prefix ...string) []Message {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	ret := make([]Message, 0)
+	for _, msg := range m.messages {
+		if hasPrefix(msg.Topic, prefix) {
+			ret = append(ret, msg)
+		}
+	}
+	return ret
+}
+
+// Points 驱动写入的数据点,按消息元数据content-type解码
+func (m *MQ) Points() ([]entity.WritePoint, error) {
+	ret := make([]entity.WritePoint, 0)
+	for _, msg := range m.Messages("data") {
+		codec, err := mq.CodecByContentType(msg.Header[mq.HeaderContentType])
+		if err != nil {
+			return nil, err
+		}
+		var p entity.WritePoint
+		if err := codec.Unmarshal(msg.Payload, &p); err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// Warnings 驱动写入的报警
+func (m *MQ) Warnings() ([]entity.WarnSend, error) {
+	ret := make([]entity.WarnSend, 0)
+	for _, msg := range m.Messages("warningStorage") {
+		var w entity.WarnSend
+		if err := json.Unmarshal(msg.Payload, &w); err != nil {
+			return nil, err
+		}
+		ret = append(ret, w)
+	}
+	return ret, nil
+}
+
+// Logs 驱动写入的日志
+func (m *MQ) Logs() []Message {
+	return m.Messages("logs")
+}
+
+// Wait 等待topic以prefix开头的消息数量达到n
+func (m *MQ) Wait(ctx context.Context, n int, prefix ...string) ([]Message, error) {
+	for {
+		m.lock.RLock()
+		notify := m.notify
+		m.lock.RUnlock()
+		if msgs := m.Messages(prefix...); len(msgs) >= n {
+			return msgs, nil
+		}
+		select {
+		case <-notify:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Reset 清空记录的消息
+func (m *MQ) Reset() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.messages = make([]Message, 0)
+}
+
+func hasPrefix(topic, prefix []string) bool {
+	if len(prefix) > len(topic) {
+		return false
+	}
+	return strings.Join(topic[:len(prefix)], "/") == strings.Join(prefix, "/")
+}
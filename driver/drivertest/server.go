@@ -0,0 +1,394 @@
+package drivertest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/air-iot/json"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+
+	pb "github.com/air-iot/api-client-go/v4/driver"
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// Server 内存中的驱动管理服务,用于驱动集成测试
+type Server struct {
+	pb.UnimplementedDriverServiceServer
+
+	lock      sync.RWMutex
+	srv       *grpc.Server
+	lis       net.Listener
+	health    pb.HealthCheckResponse_ServingStatus
+	events    []entity.Event
+	logs      []entity.Log
+	tableData []entity.TableData
+	devices   map[string]map[string]interface{}
+
+	schema    *session[*pb.SchemaRequest, *pb.SchemaResult]
+	start     *session[*pb.StartRequest, *pb.StartResult]
+	run       *session[*pb.RunRequest, *pb.RunResult]
+	writeTag  *session[*pb.RunRequest, *pb.RunResult]
+	batchRun  *session[*pb.BatchRunRequest, *pb.BatchRunResult]
+	debug     *session[*pb.Debug, *pb.Debug]
+	httpProxy *session[*pb.HttpProxyRequest, *pb.HttpProxyResult]
+}
+
+// NewServer 在本地随机端口启动驱动管理服务
+func NewServer() (*Server, error) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("监听端口错误: %w", err)
+	}
+	s := &Server{
+		lis:       lis,
+		srv:       grpc.NewServer(),
+		health:    pb.HealthCheckResponse_SERVING,
+		devices:   map[string]map[string]interface{}{},
+		schema:    newSession[*pb.SchemaRequest, *pb.SchemaResult](),
+		start:     newSession[*pb.StartRequest, *pb.StartResult](),
+		run:       newSession[*pb.RunRequest, *pb.RunResult](),
+		writeTag:  newSession[*pb.RunRequest, *pb.RunResult](),
+		batchRun:  newSession[*pb.BatchRunRequest, *pb.BatchRunResult](),
+		debug:     newSession[*pb.Debug, *pb.Debug](),
+		httpProxy: newSession[*pb.HttpProxyRequest, *pb.HttpProxyResult](),
+	}
+	pb.RegisterDriverServiceServer(s.srv, s)
+	go func() {
+		_ = s.srv.Serve(lis)
+	}()
+	return s, nil
+}
+
+// Addr 服务监听的地址和端口
+func (s *Server) Addr() (string, int) {
+	addr := s.lis.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port
+}
+
+// Close 停止服务
+func (s *Server) Close() {
+	s.srv.Stop()
+}
+
+// WaitReady 等待驱动的全部stream连接成功
+func (s *Server) WaitReady(ctx context.Context) error {
+	for _, ready := range []func() <-chan struct{}{s.schema.ready, s.start.ready, s.run.ready, s.writeTag.ready, s.batchRun.ready, s.debug.ready, s.httpProxy.ready} {
+		select {
+		case <-ready():
+		case <-ctx.Done():
+			return fmt.Errorf("等待驱动连接: %w", ctx.Err())
+		}
+	}
+	return nil
+}
+
+// SetHealth 设置健康检查返回的状态
+func (s *Server) SetHealth(status pb.HealthCheckResponse_ServingStatus) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.health = status
+}
+
+// SetDevice 设置FindDevice查询返回的设备数据
+func (s *Server) SetDevice(table, id string, data interface{}) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if _, ok := s.devices[table]; !ok {
+		s.devices[table] = map[string]interface{}{}
+	}
+	s.devices[table][id] = data
+}
+
+// Events 驱动写入的事件
+func (s *Server) Events() []entity.Event {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]entity.Event{}, s.events...)
+}
+
+// Logs 驱动写入的指令日志
+func (s *Server) Logs() []entity.Log {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]entity.Log{}, s.logs...)
+}
+
+// TableData 驱动更新的表数据
+func (s *Server) TableData() []entity.TableData {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return append([]entity.TableData{}, s.tableData...)
+}
+
+// Schema 查询驱动配置schema
+func (s *Server) Schema(ctx context.Context) (string, error) {
+	id := uuid.NewString()
+	res, err := s.schema.call(ctx, id, &pb.SchemaRequest{Request: id})
+	if err != nil {
+		return "", err
+	}
+	gr, err := decodeResult(res.GetMessage())
+	if err != nil {
+		return "", err
+	}
+	schema, _ := gr.Result.(string)
+	return schema, nil
+}
+
+// Start 下发驱动配置,config为驱动配置结构体或json字节
+func (s *Server) Start(ctx context.Context, config interface{}) error {
+	bts, ok := config.([]byte)
+	if !ok {
+		var err error
+		if bts, err = json.Marshal(config); err != nil {
+			return fmt.Errorf("序列化驱动配置错误: %w", err)
+		}
+	}
+	id := uuid.NewString()
+	res, err := s.start.call(ctx, id, &pb.StartRequest{Request: id, Config: bts})
+	if err != nil {
+		return err
+	}
+	_, err = decodeResult(res.GetMessage())
+	return err
+}
+
+// Run 下发指令
+func (s *Server) Run(ctx context.Context, cmd entity.Command) (*entity.GrpcResult, error) {
+	id := uuid.NewString()
+	res, err := s.run.call(ctx, id, &pb.RunRequest{Request: id, TableId: cmd.Table, Id: cmd.Id, SerialNo: cmd.SerialNo, Command: cmd.Command})
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(res.GetMessage())
+}
+
+// WriteTag 下发写数据点
+func (s *Server) WriteTag(ctx context.Context, cmd entity.Command) (*entity.GrpcResult, error) {
+	id := uuid.NewString()
+	res, err := s.writeTag.call(ctx, id, &pb.RunRequest{Request: id, TableId: cmd.Table, Id: cmd.Id, SerialNo: cmd.SerialNo, Command: cmd.Command})
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(res.GetMessage())
+}
+
+// BatchRun 下发批量指令
+func (s *Server) BatchRun(ctx context.Context, cmd entity.BatchCommand) (*entity.GrpcResult, error) {
+	id := uuid.NewString()
+	res, err := s.batchRun.call(ctx, id, &pb.BatchRunRequest{Request: id, TableId: cmd.Table, Id: cmd.Ids, SerialNo: cmd.SerialNo, Command: cmd.Command})
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(res.GetMessage())
+}
+
+// Debug 下发调试请求
+func (s *Server) Debug(ctx context.Context, data []byte) (*entity.GrpcResult, error) {
+	id := uuid.NewString()
+	res, err := s.debug.call(ctx, id, &pb.Debug{Request: id, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(res.GetData())
+}
+
+// HttpProxy 下发代理请求
+func (s *Server) HttpProxy(ctx context.Context, t string, header http.Header, data []byte) (*entity.GrpcResult, error) {
+	var headers []byte
+	if header != nil {
+		var err error
+		if headers, err = json.Marshal(header); err != nil {
+			return nil, fmt.Errorf("序列化请求头错误: %w", err)
+		}
+	}
+	id := uuid.NewString()
+	res, err := s.httpProxy.call(ctx, id, &pb.HttpProxyRequest{Request: id, Type: t, Headers: headers, Data: data})
+	if err != nil {
+		return nil, err
+	}
+	return decodeResult(res.GetData())
+}
+
+func (s *Server) HealthCheck(context.Context, *pb.HealthCheckRequest) (*pb.HealthCheckResponse, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return &pb.HealthCheckResponse{Status: s.health}, nil
+}
+
+func (s *Server) Event(_ context.Context, req *pb.Request) (*pb.Response, error) {
+	var event entity.Event
+	if err := json.Unmarshal(req.GetData(), &event); err != nil {
+		return &pb.Response{Status: false, Info: err.Error()}, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.events = append(s.events, event)
+	return &pb.Response{Status: true}, nil
+}
+
+func (s *Server) CommandLog(_ context.Context, req *pb.Request) (*pb.Response, error) {
+	var l entity.Log
+	if err := json.Unmarshal(req.GetData(), &l); err != nil {
+		return &pb.Response{Status: false, Info: err.Error()}, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.logs = append(s.logs, l)
+	return &pb.Response{Status: true}, nil
+}
+
+func (s *Server) UpdateTableData(_ context.Context, req *pb.Request) (*pb.Response, error) {
+	var data entity.TableData
+	if err := json.Unmarshal(req.GetData(), &data); err != nil {
+		return &pb.Response{Status: false, Info: err.Error()}, nil
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.tableData = append(s.tableData, data)
+	return &pb.Response{Status: true, Result: []byte("{}")}, nil
+}
+
+func (s *Server) FindTableData(_ context.Context, req *pb.TableDataRequest) (*pb.Response, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	data, ok := s.devices[req.GetTableId()][req.GetTableDataId()]
+	if !ok {
+		return &pb.Response{Status: false, Info: fmt.Sprintf("设备未找到: 表=%s,设备=%s", req.GetTableId(), req.GetTableDataId())}, nil
+	}
+	bts, err := json.Marshal(data)
+	if err != nil {
+		return &pb.Response{Status: false, Info: err.Error()}, nil
+	}
+	return &pb.Response{Status: true, Result: bts}, nil
+}
+
+func (s *Server) SchemaStream(stream pb.DriverService_SchemaStreamServer) error {
+	return s.schema.serve(stream.Context(), stream.Send, stream.Recv, (*pb.SchemaResult).GetRequest)
+}
+
+func (s *Server) StartStream(stream pb.DriverService_StartStreamServer) error {
+	return s.start.serve(stream.Context(), stream.Send, stream.Recv, (*pb.StartResult).GetRequest)
+}
+
+func (s *Server) RunStream(stream pb.DriverService_RunStreamServer) error {
+	return s.run.serve(stream.Context(), stream.Send, stream.Recv, (*pb.RunResult).GetRequest)
+}
+
+func (s *Server) WriteTagStream(stream pb.DriverService_WriteTagStreamServer) error {
+	return s.writeTag.serve(stream.Context(), stream.Send, stream.Recv, (*pb.RunResult).GetRequest)
+}
+
+func (s *Server) BatchRunStream(stream pb.DriverService_BatchRunStreamServer) error {
+	return s.batchRun.serve(stream.Context(), stream.Send, stream.Recv, (*pb.BatchRunResult).GetRequest)
+}
+
+func (s *Server) DebugStream(stream pb.DriverService_DebugStreamServer) error {
+	return s.debug.serve(stream.Context(), stream.Send, stream.Recv, (*pb.Debug).GetRequest)
+}
+
+func (s *Server) HttpProxyStream(stream pb.DriverService_HttpProxyStreamServer) error {
+	return s.httpProxy.serve(stream.Context(), stream.Send, stream.Recv, (*pb.HttpProxyResult).GetRequest)
+}
+
+// session 一个stream上的请求和结果,按请求id匹配结果.驱动重连时新的stream替换旧的stream
+type session[Req any, Res any] struct {
+	lock     sync.Mutex
+	sendLock sync.Mutex
+	send     func(Req) error
+	gen      int // 当前stream的序号,旧stream退出时不影响新的stream
+	pending  map[string]chan Res
+	readyCh  chan struct{} // send不为空时已关闭
+}
+
+func newSession[Req any, Res any]() *session[Req, Res] {
+	return &session[Req, Res]{pending: map[string]chan Res{}, readyCh: make(chan struct{})}
+}
+
+func (s *session[Req, Res]) ready() <-chan struct{} {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.readyCh
+}
+
+func (s *session[Req, Res]) serve(ctx context.Context, send func(Req) error, recv func() (Res, error), requestId func(Res) string) error {
+	s.lock.Lock()
+	if s.send == nil {
+		close(s.readyCh)
+	}
+	s.send = send
+	s.gen++
+	gen := s.gen
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		if s.gen == gen {
+			s.send = nil
+			s.readyCh = make(chan struct{})
+		}
+		s.lock.Unlock()
+	}()
+	for {
+		res, err := recv()
+		if err != nil {
+			return err
+		}
+		s.lock.Lock()
+		ch, ok := s.pending[requestId(res)]
+		delete(s.pending, requestId(res))
+		s.lock.Unlock()
+		if ok {
+			ch <- res
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *session[Req, Res]) call(ctx context.Context, id string, req Req) (Res, error) {
+	var zero Res
+	ch := make(chan Res, 1)
+	s.lock.Lock()
+	send := s.send
+	if send == nil {
+		s.lock.Unlock()
+		return zero, fmt.Errorf("驱动stream未连接")
+	}
+	s.pending[id] = ch
+	s.lock.Unlock()
+	s.sendLock.Lock()
+	err := send(req)
+	s.sendLock.Unlock()
+	if err != nil {
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+		return zero, fmt.Errorf("发送请求错误: %w", err)
+	}
+	select {
+	case res := <-ch:
+		return res, nil
+	case <-ctx.Done():
+		s.lock.Lock()
+		delete(s.pending, id)
+		s.lock.Unlock()
+		return zero, ctx.Err()
+	}
+}
+
+func decodeResult(bts []byte) (*entity.GrpcResult, error) {
+	gr := new(entity.GrpcResult)
+	if err := json.Unmarshal(bts, gr); err != nil {
+		return nil, fmt.Errorf("解析驱动返回结果错误: %w", err)
+	}
+	if gr.Code != 200 {
+		return gr, errors.New(gr.Error)
+	}
+	return gr, nil
+}
@@ -13,3 +13,10 @@ type BatchCommand struct {
 	SerialNo string   `json:"serialNo"`
 	Command  []byte   `json:"command"`
 }
+
+// CommandConfig 模型或设备上配置的指令
+type CommandConfig struct {
+	ID     string                 `json:"id"`     // 指令唯一标识
+	Name   string                 `json:"name"`   // 指令名称
+	Config map[string]interface{} `json:"config"` // 指令的完整配置
+}
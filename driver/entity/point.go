@@ -10,6 +10,7 @@ type Point struct {
 	FieldTypes map[string]string `json:"fieldTypes"` // 数据点类型
 }
 
+// WritePoint 发送的数据点,数据质量不正常时字段中增加 {数据点}__quality 和 {数据点}__quality__reason
 type WritePoint struct {
 	ID         string                 `json:"id"`
 	CID        string                 `json:"cid"`    // 子设备编号
@@ -21,6 +22,8 @@ type WritePoint struct {
 
 // Field 字段
 type Field struct {
-	Tag   Tag         `json:"tag"`   // 数据点
-	Value interface{} `json:"value"` // 数据采集值
+	Tag     Tag           `json:"tag"`     // 数据点
+	Value   interface{}   `json:"value"`   // 数据采集值
+	Quality Quality       `json:"quality"` // 数据质量,为空时为正常
+	Reason  QualityReason `json:"reason"`  // 数据质量原因
 }
@@ -0,0 +1,163 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// WritePoint 的protobuf结构,数值类型的字段值编码为double:
+//
+//	message WritePoint {
+//	  string id = 1;
+//	  string cid = 2;
+//	  string source = 3;
+//	  map<string, google.protobuf.Value> fields = 4;
+//	  int64 time = 5;
+//	  map<string, string> fieldTypes = 6;
+//	}
+const (
+	writePointID         protowire.Number = 1
+	writePointCID        protowire.Number = 2
+	writePointSource     protowire.Number = 3
+	writePointFields     protowire.Number = 4
+	writePointTime       protowire.Number = 5
+	writePointFieldTypes protowire.Number = 6
+)
+
+// MarshalProto protobuf编码
+func (p *WritePoint) MarshalProto() ([]byte, error) {
+	var b []byte
+	b = appendProtoString(b, writePointID, p.ID)
+	b = appendProtoString(b, writePointCID, p.CID)
+	b = appendProtoString(b, writePointSource, p.Source)
+	for k, v := range p.Fields {
+		val, err := structpb.NewValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("数据点 %s 的值不支持protobuf编码: %w", k, err)
+		}
+		vb, err := proto.Marshal(val)
+		if err != nil {
+			return nil, err
+		}
+		entry := appendProtoString(nil, 1, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendBytes(entry, vb)
+		b = protowire.AppendTag(b, writePointFields, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	if p.UnixTime != 0 {
+		b = protowire.AppendTag(b, writePointTime, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(p.UnixTime))
+	}
+	for k, v := range p.FieldTypes {
+		entry := appendProtoString(appendProtoString(nil, 1, k), 2, v)
+		b = protowire.AppendTag(b, writePointFieldTypes, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b, nil
+}
+
+// UnmarshalProto protobuf解码
+func (p *WritePoint) UnmarshalProto(b []byte) error {
+	*p = WritePoint{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType && !(num == writePointTime && typ == protowire.VarintType) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+		if typ == protowire.VarintType {
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			p.UnixTime = int64(v)
+			b = b[n:]
+			continue
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case writePointID:
+			p.ID = string(v)
+		case writePointCID:
+			p.CID = string(v)
+		case writePointSource:
+			p.Source = string(v)
+		case writePointFields:
+			k, vb, err := consumeProtoMapEntry(v)
+			if err != nil {
+				return err
+			}
+			val := new(structpb.Value)
+			if err := proto.Unmarshal(vb, val); err != nil {
+				return err
+			}
+			if p.Fields == nil {
+				p.Fields = map[string]interface{}{}
+			}
+			p.Fields[k] = val.AsInterface()
+		case writePointFieldTypes:
+			k, vb, err := consumeProtoMapEntry(v)
+			if err != nil {
+				return err
+			}
+			if p.FieldTypes == nil {
+				p.FieldTypes = map[string]string{}
+			}
+			p.FieldTypes[k] = string(vb)
+		}
+	}
+	return nil
+}
+
+func appendProtoString(b []byte, num protowire.Number, s string) []byte {
+	if s == "" {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.BytesType)
+	return protowire.AppendString(b, s)
+}
+
+// consumeProtoMapEntry 解析map条目,key和value的字段号分别为1和2
+func consumeProtoMapEntry(b []byte) (string, []byte, error) {
+	var key string
+	var val []byte
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		if typ != protowire.BytesType {
+			return "", nil, errors.New("protobuf map条目格式错误")
+		}
+		v, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return "", nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			key = string(v)
+		case 2:
+			val = v
+		}
+	}
+	return key, val, nil
+}
@@ -0,0 +1,31 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWritePointProto(t *testing.T) {
+	in := WritePoint{
+		ID:         "d1",
+		CID:        "c1",
+		Source:     "device",
+		UnixTime:   1700000000000,
+		Fields:     map[string]interface{}{"p1": 1.5, "p2": "on", "p3": true, "p1__quality": "bad"},
+		FieldTypes: map[string]string{"p1": "float"},
+	}
+	b, err := in.MarshalProto()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out WritePoint
+	if err := out.UnmarshalProto(b); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("解码结果应为=%+v,实际为=%+v", in, out)
+	}
+	if _, err := (&WritePoint{Fields: map[string]interface{}{"p1": struct{}{}}}).MarshalProto(); err == nil {
+		t.Error("不支持的字段值应返回错误")
+	}
+}
@@ -0,0 +1,42 @@
+package entity
+
+// Quality 数据质量
+type Quality string
+
+const (
+	QualityGood      Quality = "good"      // 正常
+	QualityUncertain Quality = "uncertain" // 不确定,如替换值、上次的值
+	QualityBad       Quality = "bad"       // 不可用,如通讯失败
+)
+
+// QualityReason 数据质量原因
+type QualityReason string
+
+const (
+	ReasonSubstituted   QualityReason = "substituted"   // 替换为固定值
+	ReasonClamped       QualityReason = "clamped"       // 替换为边界值
+	ReasonLastKnown     QualityReason = "lastKnown"     // 使用上次的值
+	ReasonOutOfRange    QualityReason = "outOfRange"    // 超出有效范围
+	ReasonStale         QualityReason = "stale"         // 数据未更新
+	ReasonCommFailure   QualityReason = "commFailure"   // 通讯失败
+	ReasonDeviceFailure QualityReason = "deviceFailure" // 设备故障
+	ReasonSensorFailure QualityReason = "sensorFailure" // 传感器故障
+	ReasonConfigError   QualityReason = "configError"   // 配置错误
+)
+
+var qualityLevel = map[Quality]int{
+	"":               0,
+	QualityGood:      0,
+	QualityUncertain: 1,
+	QualityBad:       2,
+}
+
+// IsGood 数据质量为空或正常
+func (q Quality) IsGood() bool {
+	return qualityLevel[q] == 0
+}
+
+// Worse 数据质量是否比传入的数据质量差
+func (q Quality) Worse(o Quality) bool {
+	return qualityLevel[q] > qualityLevel[o]
+}
@@ -8,6 +8,7 @@ type Tag struct {
 	Fixed    *int32    `json:"fixed"`
 	Mod      *float64  `json:"mod"`
 	Range    *Range    `json:"range"`
+	Deadband *Deadband `json:"deadband"`
 }
 
 type TagValue struct {
@@ -17,6 +18,13 @@ type TagValue struct {
 	MaxRaw   *float64 `json:"maxRaw"`
 }
 
+// Deadband 死区上报,变化量未超过死区的数据点不上报
+type Deadband struct {
+	Absolute   *float64 `json:"absolute"`   // 与上次上报值差值的绝对值
+	Percent    *float64 `json:"percent"`    // 与上次上报值差值的百分比
+	MaxSilence *float64 `json:"maxSilence"` // 最长不上报时间(秒),超过后即使未变化也上报
+}
+
 type RangeMethod string
 
 const (
@@ -1,11 +1,18 @@
 package driver
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"net"
+	"os"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/air-iot/logger"
+	"google.golang.org/grpc/status"
 )
 
 type ErrorType int
@@ -20,30 +27,228 @@ const (
 	MODBUS_ILLEGAL_DATA_ADDRESS ErrorType = 7
 )
 
+// ErrorTypeCustom 协议包自定义错误类型的起始值
+const ErrorTypeCustom ErrorType = 100
+
+// Classifier 错误分类,无法识别时返回false
+type Classifier func(err error) (ErrorType, bool)
+
+type errorTypeInfo struct {
+	name    string
+	suggest string
+}
+
+type namedClassifier struct {
+	name       string
+	classifier Classifier
+}
+
+var (
+	errorLock  sync.RWMutex
+	errorTypes = map[ErrorType]errorTypeInfo{
+		UNKONWN:                     {name: "unknown"},
+		TIMEOUT:                     {name: "timeout", suggest: "检查网络是否延时;检查服务端设备资源(CPU、内存等)占用是否过高资源不够(降低采集频率)"},
+		CONNECTION_FAIELD:           {name: "connectionFailed", suggest: "检查服务端设备是否开机,网络端口是否通,防火墙端口是否开放"},
+		CONNECTION_CLOSED:           {name: "connectionClosed", suggest: "检查服务端设备是否超过了最大连接数"},
+		CONNECTION_EOF:              {name: "connectionEOF", suggest: "检查服务端设备是否关闭了连接"},
+		MODBUS_TRANSACTION:          {name: "modbusTransaction", suggest: "检查服务端设备是否有多个连接在读写引起事务不一致"},
+		MODBUS_ILLEGAL_DATA_ADDRESS: {name: "modbusIllegalDataAddress", suggest: "检查站号和数据点地址是否配置正确"},
+	}
+	classifiers = []namedClassifier{{name: "modbus", classifier: modbusClassifier}}
+)
+
+// RegisterErrorType 注册错误类型的名称和处理建议,类型已存在时返回错误
+func RegisterErrorType(t ErrorType, name, suggest string) error {
+	errorLock.Lock()
+	defer errorLock.Unlock()
+	if info, ok := errorTypes[t]; ok {
+		return fmt.Errorf("错误类型 %d 已注册为 %s", t, info.name)
+	}
+	errorTypes[t] = errorTypeInfo{name: name, suggest: suggest}
+	return nil
+}
+
+// RegisterClassifier 注册协议错误分类,协议分类优先于网络错误分类,名称已存在时返回错误
+func RegisterClassifier(name string, c Classifier) error {
+	errorLock.Lock()
+	defer errorLock.Unlock()
+	for _, nc := range classifiers {
+		if nc.name == name {
+			return fmt.Errorf("错误分类 %s 已注册", name)
+		}
+	}
+	classifiers = append(classifiers, namedClassifier{name: name, classifier: c})
+	return nil
+}
+
+// String 错误类型名称
+func (t ErrorType) String() string {
+	errorLock.RLock()
+	defer errorLock.RUnlock()
+	if info, ok := errorTypes[t]; ok {
+		return info.name
+	}
+	return fmt.Sprintf("ErrorType(%d)", int(t))
+}
+
+// ClassifyError 错误分类,依次使用传入名称对应的协议分类和网络错误分类,未传入名称时使用全部已注册的协议分类
+func ClassifyError(err error, names ...string) ErrorType {
+	if err == nil {
+		return UNKONWN
+	}
+	errorLock.RLock()
+	cs := make([]Classifier, 0, len(classifiers)+1)
+	for _, nc := range classifiers {
+		if len(names) == 0 || contains(names, nc.name) {
+			cs = append(cs, nc.classifier)
+		}
+	}
+	errorLock.RUnlock()
+	return classify(err, append(cs, netClassifier))
+}
+
+// SuggestError 错误分类并附加处理建议,未识别的错误原样返回
+func SuggestError(err error, names ...string) (ErrorType, error) {
+	return suggest(ClassifyError(err, names...), err)
+}
+
+// TcpClientErrSuggest 网络错误分类并附加处理建议
 func TcpClientErrSuggest(err error) (ErrorType, error) {
-	if strings.Contains(err.Error(), "timeout") {
-		return TIMEOUT, logger.NewErrorFocusNotice("检查网络是否延时;检查服务端设备资源(CPU、内存等)占用是否过高资源不够(降低采集频率)", err)
-	} else if strings.Contains(err.Error(), "An established connection was aborted by the software in your host machine") ||
-		strings.Contains(err.Error(), "An existing connection was forcibly closed by the remote host") ||
-		strings.Contains(err.Error(), "No connection could be made because the target machine actively refused it") ||
-		strings.Contains(err.Error(), "connection refused") {
-		return CONNECTION_FAIELD, logger.NewErrorFocusNotice("检查服务端设备是否开机,网络端口是否通,防火墙端口是否开放", err)
-	} else if strings.Contains(err.Error(), "broken pipe") ||
-		strings.Contains(err.Error(), "use of closed network connection") ||
-		strings.Contains(err.Error(), "connection reset by peer") {
-		return CONNECTION_CLOSED, logger.NewErrorFocusNotice("检查服务端设备是否超过了最大连接数", err)
-	} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
-		return CONNECTION_EOF, logger.NewErrorFocusNotice("检查服务端设备是否关闭了连接", err)
-	}
-	return UNKONWN, err
+	return suggest(classify(err, []Classifier{netClassifier}), err)
 }
 
+// ModbusErrSuggest Modbus和网络错误分类并附加处理建议
 func ModbusErrSuggest(err error) (ErrorType, error) {
-	if strings.Contains(err.Error(), "modbus: response transaction id") && strings.Contains(err.Error(), "does not match request") {
-		return MODBUS_TRANSACTION, logger.NewErrorFocusNotice("检查服务端设备是否有多个连接在读写引起事务不一致", err)
-	} else if strings.Contains(err.Error(), "illegal data address") {
-		return MODBUS_ILLEGAL_DATA_ADDRESS, logger.NewErrorFocusNotice("检查站号和数据点地址是否配置正确", err)
-	} else {
-		return TcpClientErrSuggest(err)
+	return SuggestError(err, "modbus")
+}
+
+func classify(err error, cs []Classifier) ErrorType {
+	for _, c := range cs {
+		if t, ok := c(err); ok {
+			return t
+		}
+	}
+	return UNKONWN
+}
+
+func suggest(t ErrorType, err error) (ErrorType, error) {
+	errorLock.RLock()
+	info := errorTypes[t]
+	errorLock.RUnlock()
+	if t == UNKONWN || info.suggest == "" {
+		return t, err
 	}
+	return t, logger.NewErrorFocusNotice(info.suggest, err)
+}
+
+func contains(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// netClassifier 网络错误分类
+func netClassifier(err error) (ErrorType, bool) {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return TIMEOUT, true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return TIMEOUT, true
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNABORTED),
+		errors.Is(err, syscall.EHOSTUNREACH), errors.Is(err, syscall.ENETUNREACH):
+		return CONNECTION_FAIELD, true
+	case errors.Is(err, syscall.EPIPE), errors.Is(err, syscall.ECONNRESET), errors.Is(err, net.ErrClosed):
+		return CONNECTION_CLOSED, true
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return CONNECTION_EOF, true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return CONNECTION_FAIELD, true
+	}
+	if t, ok := platformClassifier(err); ok {
+		return t, true
+	}
+	if _, ok := status.FromError(err); ok {
+		return messageClassifier(err)
+	}
+	return UNKONWN, false
+}
+
+// messageClassifier 按错误信息分类,仅用于gRPC状态错误,其错误信息由远端转为字符串,
+// 其中包含Windows驱动返回的系统错误信息.本地错误通过errors.Is、errors.As分类,不按错误信息匹配
+func messageClassifier(err error) (ErrorType, bool) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "timeout"):
+		return TIMEOUT, true
+	case strings.Contains(msg, "An established connection was aborted by the software in your host machine"),
+		strings.Contains(msg, "An existing connection was forcibly closed by the remote host"),
+		strings.Contains(msg, "No connection could be made because the target machine actively refused it"),
+		strings.Contains(msg, "connection refused"):
+		return CONNECTION_FAIELD, true
+	case strings.Contains(msg, "broken pipe"),
+		strings.Contains(msg, "use of closed network connection"),
+		strings.Contains(msg, "connection reset by peer"):
+		return CONNECTION_CLOSED, true
+	}
+	return UNKONWN, false
+}
+
+func modbusClassifier(err error) (ErrorType, bool) {
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "modbus: response transaction id") && strings.Contains(msg, "does not match request"):
+		return MODBUS_TRANSACTION, true
+	case strings.Contains(msg, "illegal data address"):
+		return MODBUS_ILLEGAL_DATA_ADDRESS, true
+	}
+	return UNKONWN, false
+}
+
+// errorStats 设备错误分类计数
+type errorStats struct {
+	lock   sync.Mutex
+	counts map[string]map[ErrorType]uint64
+}
+
+func (s *errorStats) add(table, id string, t ErrorType) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]map[ErrorType]uint64{}
+	}
+	key := table + "/" + id
+	m, ok := s.counts[key]
+	if !ok {
+		m = map[ErrorType]uint64{}
+		s.counts[key] = m
+	}
+	m[t]++
+}
+
+func (s *errorStats) get(table, id string) map[ErrorType]uint64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ret := map[ErrorType]uint64{}
+	for t, n := range s.counts[table+"/"+id] {
+		ret[t] = n
+	}
+	return ret
+}
+
+// ReportError 设备错误分类并计数,返回附加处理建议的错误
+func (a *app) ReportError(table, id string, err error, names ...string) (ErrorType, error) {
+	t, suggestErr := SuggestError(err, names...)
+	a.errorStats.add(table, id, t)
+	return t, suggestErr
+}
+
+// ErrorCounts 设备各错误类型的次数
+func (a *app) ErrorCounts(table, id string) map[ErrorType]uint64 {
+	return a.errorStats.get(table, id)
 }
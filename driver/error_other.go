@@ -0,0 +1,8 @@
+//go:build !windows
+
+package driver
+
+// platformClassifier 非Windows系统的错误码已由netClassifier通过syscall分类
+func platformClassifier(error) (ErrorType, bool) {
+	return UNKONWN, false
+}
@@ -0,0 +1,68 @@
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorType
+	}{
+		{"deadline", fmt.Errorf("read: %w", os.ErrDeadlineExceeded), TIMEOUT},
+		{"refused", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, CONNECTION_FAIELD},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}, CONNECTION_CLOSED},
+		{"closed", fmt.Errorf("write: %w", net.ErrClosed), CONNECTION_CLOSED},
+		{"eof", fmt.Errorf("read: %w", io.EOF), CONNECTION_EOF},
+		{"grpc windows", status.Error(codes.Unavailable, "wsarecv: An existing connection was forcibly closed by the remote host."), CONNECTION_FAIELD},
+		{"grpc timeout", fmt.Errorf("执行指令: %w", status.Error(codes.Unknown, "read tcp 10.0.0.1:502: i/o timeout")), TIMEOUT},
+		{"local timeout", errors.New("timeout must be positive"), UNKONWN},
+		{"local connection", errors.New("connection refused by config: port not allowed"), UNKONWN},
+		{"modbus", errors.New("modbus: exception '2' (illegal data address), function '3'"), MODBUS_ILLEGAL_DATA_ADDRESS},
+		{"unknown", errors.New("unknown"), UNKONWN},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("%s: 错误类型应为=%s,实际为=%s", tt.name, tt.want, got)
+		}
+	}
+	if got, _ := TcpClientErrSuggest(errors.New("illegal data address")); got != UNKONWN {
+		t.Errorf("网络错误分类不应识别Modbus错误,实际为=%s", got)
+	}
+}
+
+func TestRegisterClassifier(t *testing.T) {
+	const custom = ErrorTypeCustom + 1
+	errCustom := errors.New("custom protocol error")
+	if err := RegisterErrorType(custom, "custom", "检查自定义协议"); err != nil {
+		t.Fatal(err)
+	}
+	if err := RegisterErrorType(custom, "custom", ""); err == nil {
+		t.Fatal("重复注册错误类型应返回错误")
+	}
+	if err := RegisterClassifier("custom", func(err error) (ErrorType, bool) {
+		return custom, errors.Is(err, errCustom)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	a := new(app)
+	typ, err := a.ReportError("t1", "d1", fmt.Errorf("read: %w", errCustom), "custom")
+	if typ != custom || err == nil {
+		t.Fatalf("错误类型应为=%s,实际为=%s", custom, typ)
+	}
+	_, _ = a.ReportError("t1", "d1", io.EOF)
+	counts := a.ErrorCounts("t1", "d1")
+	if counts[custom] != 1 || counts[CONNECTION_EOF] != 1 {
+		t.Fatalf("错误计数不匹配: %v", counts)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build windows
+
+package driver
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows Socket错误码,syscall中未定义的部分
+const (
+	wsaeNetUnreach  syscall.Errno = 10051
+	wsaeTimedOut    syscall.Errno = 10060
+	wsaeConnRefused syscall.Errno = 10061
+	wsaeHostUnreach syscall.Errno = 10065
+)
+
+// platformClassifier Windows系统错误分类,Windows的Socket错误码与syscall中的ECONNREFUSED等不同
+func platformClassifier(err error) (ErrorType, bool) {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return UNKONWN, false
+	}
+	switch errno {
+	case wsaeTimedOut:
+		return TIMEOUT, true
+	case wsaeConnRefused, syscall.WSAECONNRESET, syscall.WSAECONNABORTED, wsaeHostUnreach, wsaeNetUnreach:
+		return CONNECTION_FAIELD, true
+	}
+	return UNKONWN, false
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package driver
+
+import (
+	"net"
+	"os"
+	"syscall"
+	"testing"
+)
+
+func TestClassifyErrorWindows(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want ErrorType
+	}{
+		{"refused", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("wsarecv", wsaeConnRefused)}, CONNECTION_FAIELD},
+		{"reset", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("wsarecv", syscall.WSAECONNRESET)}, CONNECTION_FAIELD},
+		{"aborted", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("wsasend", syscall.WSAECONNABORTED)}, CONNECTION_FAIELD},
+	}
+	for _, tt := range tests {
+		if got := ClassifyError(tt.err); got != tt.want {
+			t.Errorf("%s: 错误类型应为=%s,实际为=%s", tt.name, tt.want, got)
+		}
+	}
+}
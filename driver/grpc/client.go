@@ -6,6 +6,9 @@ import (
 	"time"
 
 	"google.golang.org/grpc/metadata"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
 )
 
 type Config struct {
@@ -18,6 +21,8 @@ type Config struct {
 	WaitTime time.Duration `json:"waitTime" yaml:"waitTime"`
 	Timeout  time.Duration `json:"timeout" yaml:"timeout"`
 	Limit    int           `json:"limit" yaml:"limit"`
+	TLS      tlsx.Config   `json:"tls" yaml:"tls"`
+	Token    grpcx.Token   `json:"token" yaml:"token"` // 认证token,每次请求以Bearer token携带
 }
 
 func GetGrpcContext(ctx context.Context, serviceId, projectId, driverId, driverName string) context.Context {
@@ -0,0 +1,131 @@
+package driver
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// driverMetrics 驱动运行指标,每个App使用自己的注册表,通过pprof监听地址的/metrics查询.
+// 未初始化(nil)时不记录指标
+type driverMetrics struct {
+	registry        *prometheus.Registry
+	pointsPublished *prometheus.CounterVec
+	publishFailures *prometheus.CounterVec
+	fieldsDropped   *prometheus.CounterVec
+	commandDuration *prometheus.HistogramVec
+	grpcReconnects  *prometheus.CounterVec
+	healthChecks    *prometheus.CounterVec
+	grpcStreams     *prometheus.GaugeVec
+}
+
+func newDriverMetrics() *driverMetrics {
+	m := &driverMetrics{
+		registry: prometheus.NewRegistry(),
+		pointsPublished: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airiot_driver_points_published_total",
+			Help: "发送成功的数据点消息数量",
+		}, []string{"driver", "table"}),
+		publishFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airiot_driver_publish_failures_total",
+			Help: "发送失败的数据点消息数量",
+		}, []string{"driver", "table"}),
+		fieldsDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airiot_driver_fields_dropped_total",
+			Help: "未发送的数据点字段数量",
+		}, []string{"driver", "table", "reason"}),
+		commandDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "airiot_driver_command_duration_seconds",
+			Help:    "驱动处理指令耗时",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"driver", "table", "stream"}),
+		grpcReconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airiot_driver_grpc_reconnects_total",
+			Help: "驱动管理重连次数",
+		}, []string{"driver"}),
+		healthChecks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "airiot_driver_health_checks_total",
+			Help: "健康检查次数",
+		}, []string{"driver", "result"}),
+		grpcStreams: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "airiot_driver_grpc_streams",
+			Help: "驱动管理已连接的流数量",
+		}, []string{"driver"}),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.pointsPublished,
+		m.publishFailures,
+		m.fieldsDropped,
+		m.commandDuration,
+		m.grpcReconnects,
+		m.healthChecks,
+		m.grpcStreams,
+	)
+	return m
+}
+
+// handler 指标查询接口
+func (m *driverMetrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeCommand 记录指令处理耗时
+func (m *driverMetrics) observeCommand(stream, table string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.commandDuration.WithLabelValues(Cfg.Driver.ID, table, stream).Observe(time.Since(start).Seconds())
+}
+
+// observePublish 记录数据点消息发送结果
+func (m *driverMetrics) observePublish(topic []string, err error) {
+	if m == nil {
+		return
+	}
+	table := ""
+	if len(topic) > 2 {
+		table = topic[2]
+	}
+	if err != nil {
+		m.publishFailures.WithLabelValues(Cfg.Driver.ID, table).Inc()
+		return
+	}
+	m.pointsPublished.WithLabelValues(Cfg.Driver.ID, table).Inc()
+}
+
+// dropField 记录未发送的数据点字段
+func (m *driverMetrics) dropField(table, reason string) {
+	if m == nil {
+		return
+	}
+	m.fieldsDropped.WithLabelValues(Cfg.Driver.ID, table, reason).Inc()
+}
+
+// reconnect 记录驱动管理重连
+func (m *driverMetrics) reconnect() {
+	if m == nil {
+		return
+	}
+	m.grpcReconnects.WithLabelValues(Cfg.Driver.ID).Inc()
+}
+
+// healthCheck 记录健康检查结果
+func (m *driverMetrics) healthCheck(result string) {
+	if m == nil {
+		return
+	}
+	m.healthChecks.WithLabelValues(Cfg.Driver.ID, result).Inc()
+}
+
+// setStreams 记录已连接的流数量
+func (m *driverMetrics) setStreams(n int32) {
+	if m == nil {
+		return
+	}
+	m.grpcStreams.WithLabelValues(Cfg.Driver.ID).Set(float64(n))
+}
@@ -0,0 +1,46 @@
+package driver
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDriverMetrics(t *testing.T) {
+	// 每个App使用自己的注册表,多次创建不会重复注册
+	m1, m2 := newDriverMetrics(), newDriverMetrics()
+	m1.observePublish([]string{"data", "p1", "t1", "d1"}, nil)
+	m1.observePublish([]string{"data", "p1", "t1", "d1"}, nil)
+	m1.observePublish([]string{"data", "p1", "t1", "d1"}, errors.New("超时"))
+	m2.dropField("t1", "range")
+	var m3 *driverMetrics
+	m3.observePublish([]string{"data"}, nil)
+
+	scrape := func(m *driverMetrics) string {
+		rec := httptest.NewRecorder()
+		m.handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+		bts, err := io.ReadAll(rec.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bts)
+	}
+	out := scrape(m1)
+	for _, want := range []string{
+		`airiot_driver_points_published_total{driver="` + Cfg.Driver.ID + `",table="t1"} 2`,
+		`airiot_driver_publish_failures_total{driver="` + Cfg.Driver.ID + `",table="t1"} 1`,
+		"go_goroutines",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("指标输出应包含=%s,实际为:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "airiot_driver_fields_dropped_total{") {
+		t.Error("不同App的指标不应共享")
+	}
+	if out := scrape(m2); !strings.Contains(out, `airiot_driver_fields_dropped_total{driver="`+Cfg.Driver.ID+`",reason="range",table="t1"} 1`) {
+		t.Errorf("未发送字段指标错误:\n%s", out)
+	}
+}
@@ -0,0 +1,217 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/air-iot/logger"
+)
+
+// BackpressurePolicy 发送队列满时的处理策略
+type BackpressurePolicy string
+
+const (
+	PolicyBlock      BackpressurePolicy = "block"      // 阻塞等待队列空闲
+	PolicyDropOldest BackpressurePolicy = "dropOldest" // 丢弃队列中最早的数据
+	PolicyDropNewest BackpressurePolicy = "dropNewest" // 丢弃当前写入的数据
+)
+
+// ErrQueueFull 发送队列已满
+var ErrQueueFull = errors.New("发送队列已满,丢弃数据")
+
+// PipelineConfig 异步发送数据点配置
+type PipelineConfig struct {
+	Enable    bool               `json:"enable" yaml:"enable"`
+	QueueSize int                `json:"queueSize" yaml:"queueSize"` // 发送队列长度
+	Workers   int                `json:"workers" yaml:"workers"`     // 发送协程数
+	BatchSize int                `json:"batchSize" yaml:"batchSize"` // 每批发送的最大数量
+	Linger    time.Duration      `json:"linger" yaml:"linger"`       // 凑批等待时间,批次未满时最多等待该时间后发送
+	Policy    BackpressurePolicy `json:"policy" yaml:"policy"`       // 队列满时的处理策略
+}
+
+type message struct {
+	topic   []string
+	payload []byte
+}
+
+type publishFunc func(ctx context.Context, topic []string, payload []byte) error
+
+// pipeline 异步发送队列,多个协程并发按批发送,不保证数据顺序
+type pipeline struct {
+	cfg     PipelineConfig
+	queue   chan *message
+	publish publishFunc
+	timeout time.Duration
+	pending int64
+	stop    chan struct{}
+	once    sync.Once
+	wg      sync.WaitGroup
+}
+
+func newPipeline(cfg PipelineConfig, timeout time.Duration, publish publishFunc) *pipeline {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 10000
+	}
+	if cfg.Workers <= 0 {
+		cfg.Workers = 1
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 1
+	}
+	if cfg.Policy == "" {
+		cfg.Policy = PolicyBlock
+	}
+	p := &pipeline{
+		cfg:     cfg,
+		queue:   make(chan *message, cfg.QueueSize),
+		publish: publish,
+		timeout: timeout,
+		stop:    make(chan struct{}),
+	}
+	for i := 0; i < cfg.Workers; i++ {
+		p.wg.Add(1)
+		go p.work()
+	}
+	return p
+}
+
+// push 写入发送队列
+func (p *pipeline) push(ctx context.Context, topic []string, payload []byte) error {
+	msg := &message{topic: topic, payload: payload}
+	atomic.AddInt64(&p.pending, 1)
+	switch p.cfg.Policy {
+	case PolicyDropNewest:
+		select {
+		case p.queue <- msg:
+			return nil
+		default:
+			atomic.AddInt64(&p.pending, -1)
+			return ErrQueueFull
+		}
+	case PolicyDropOldest:
+		for {
+			select {
+			case p.queue <- msg:
+				return nil
+			default:
+			}
+			select {
+			case old := <-p.queue:
+				atomic.AddInt64(&p.pending, -1)
+				logger.Warnf("异步发送: topic=%v. 发送队列已满,丢弃最早的数据", old.topic)
+			default:
+			}
+		}
+	default:
+		select {
+		case p.queue <- msg:
+			return nil
+		case <-ctx.Done():
+			atomic.AddInt64(&p.pending, -1)
+			return ctx.Err()
+		}
+	}
+}
+
+// flush 等待队列中的数据发送完成
+func (p *pipeline) flush(ctx context.Context) error {
+	ticker := time.NewTicker(time.Millisecond * 10)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&p.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// close 停止发送协程,队列中剩余的数据发送完成后退出
+func (p *pipeline) close() {
+	p.once.Do(func() {
+		close(p.stop)
+	})
+	p.wg.Wait()
+}
+
+func (p *pipeline) work() {
+	defer p.wg.Done()
+	batch := make([]*message, 0, p.cfg.BatchSize)
+	for {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch, msg)
+		case <-p.stop:
+			p.drain(batch)
+			return
+		}
+		batch = p.collect(batch)
+		p.send(batch)
+		batch = batch[:0]
+	}
+}
+
+// collect 凑批,批次已满或等待超过Linger时返回
+func (p *pipeline) collect(batch []*message) []*message {
+	if p.cfg.Linger <= 0 {
+		for len(batch) < p.cfg.BatchSize {
+			select {
+			case msg := <-p.queue:
+				batch = append(batch, msg)
+			default:
+				return batch
+			}
+		}
+		return batch
+	}
+	timer := time.NewTimer(p.cfg.Linger)
+	defer timer.Stop()
+	for len(batch) < p.cfg.BatchSize {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch, msg)
+		case <-timer.C:
+			return batch
+		case <-p.stop:
+			return batch
+		}
+	}
+	return batch
+}
+
+// drain 停止时发送队列中剩余的数据
+func (p *pipeline) drain(batch []*message) {
+	for {
+		select {
+		case msg := <-p.queue:
+			batch = append(batch, msg)
+			if len(batch) == p.cfg.BatchSize {
+				p.send(batch)
+				batch = batch[:0]
+			}
+		default:
+			p.send(batch)
+			return
+		}
+	}
+}
+
+// send 发送一批数据,每条数据单独计算超时时间,避免批次靠后的数据因前面的数据发送慢而超时
+func (p *pipeline) send(batch []*message) {
+	for _, msg := range batch {
+		p.sendOne(msg)
+	}
+}
+
+func (p *pipeline) sendOne(msg *message) {
+	defer atomic.AddInt64(&p.pending, -1)
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+	if err := p.publish(ctx, msg.topic, msg.payload); err != nil {
+		logger.Errorf("异步发送: topic=%v. 发送错误: %v", msg.topic, err)
+	}
+}
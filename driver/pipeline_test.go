@@ -0,0 +1,141 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPipeline_Flush(t *testing.T) {
+	var lock sync.Mutex
+	received := 0
+	p := newPipeline(PipelineConfig{QueueSize: 10, Workers: 2}, time.Second, func(ctx context.Context, topic []string, payload []byte) error {
+		lock.Lock()
+		defer lock.Unlock()
+		received++
+		return nil
+	})
+	defer p.close()
+	for i := 0; i < 100; i++ {
+		if err := p.push(context.Background(), []string{"data"}, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := p.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if received != 100 {
+		t.Fatalf("发送数量不匹配,应为=100,实际为=%d", received)
+	}
+}
+
+func TestPipeline_DropNewest(t *testing.T) {
+	block := make(chan struct{})
+	p := newPipeline(PipelineConfig{QueueSize: 1, Workers: 1, Policy: PolicyDropNewest}, time.Second, func(ctx context.Context, topic []string, payload []byte) error {
+		<-block
+		return nil
+	})
+	defer p.close()
+	var err error
+	for i := 0; i < 10 && err == nil; i++ {
+		err = p.push(context.Background(), []string{"data"}, []byte("1"))
+	}
+	close(block)
+	if !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("队列满时应丢弃数据: %v", err)
+	}
+}
+
+func TestPipeline_Timeout(t *testing.T) {
+	var lock sync.Mutex
+	remains := make([]time.Duration, 0)
+	p := newPipeline(PipelineConfig{QueueSize: 10, Workers: 1}, time.Millisecond*100, func(ctx context.Context, topic []string, payload []byte) error {
+		deadline, _ := ctx.Deadline()
+		lock.Lock()
+		remains = append(remains, time.Until(deadline))
+		lock.Unlock()
+		time.Sleep(time.Millisecond * 60)
+		return nil
+	})
+	defer p.close()
+	for i := 0; i < 3; i++ {
+		if err := p.push(context.Background(), []string{"data"}, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := p.flush(ctx); err != nil {
+		t.Fatal(err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for i, remain := range remains {
+		if remain < time.Millisecond*80 {
+			t.Fatalf("第%d条数据的超时时间应单独计算,剩余=%s", i+1, remain)
+		}
+	}
+}
+
+func TestPipeline_Batch(t *testing.T) {
+	received := make(chan string, 10)
+	p := newPipeline(PipelineConfig{QueueSize: 10, Workers: 1, BatchSize: 3, Linger: time.Hour}, time.Second, func(ctx context.Context, topic []string, payload []byte) error {
+		received <- string(payload)
+		return nil
+	})
+	defer p.close()
+	for _, v := range []string{"1", "2"} {
+		if err := p.push(context.Background(), []string{"data"}, []byte(v)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case v := <-received:
+		t.Fatalf("批次未满且未到等待时间时不应发送,收到=%s", v)
+	case <-time.After(time.Millisecond * 100):
+	}
+	if err := p.push(context.Background(), []string{"data"}, []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		select {
+		case v := <-received:
+			if v != want {
+				t.Fatalf("发送数据应为=%s,实际为=%s", want, v)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("批次已满时应立即发送")
+		}
+	}
+}
+
+func TestPipeline_Linger(t *testing.T) {
+	received := make(chan time.Time, 10)
+	p := newPipeline(PipelineConfig{QueueSize: 10, Workers: 1, BatchSize: 10, Linger: time.Millisecond * 200}, time.Second, func(ctx context.Context, topic []string, payload []byte) error {
+		received <- time.Now()
+		return nil
+	})
+	defer p.close()
+	start := time.Now()
+	for i := 0; i < 2; i++ {
+		if err := p.push(context.Background(), []string{"data"}, []byte("1")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case sent := <-received:
+			if elapsed := sent.Sub(start); elapsed < time.Millisecond*200 {
+				t.Fatalf("未满的批次应等待Linger后发送,实际等待=%s", elapsed)
+			}
+		case <-time.After(time.Second * 5):
+			t.Fatal("等待Linger后未满的批次应发送")
+		}
+	}
+}
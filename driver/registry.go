@@ -0,0 +1,89 @@
+package driver
+
+import (
+	"sync"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// Device 解析后的设备模型,配置、数据点和指令已合并所属模型
+type Device = DeviceConfig[map[string]interface{}, map[string]interface{}]
+
+// registry 驱动启动配置中的设备模型
+type registry struct {
+	lock    sync.RWMutex
+	devices map[string]map[string]*Device
+	list    []*Device
+	tags    map[*Device]map[string]entity.Tag
+}
+
+// load 使用新的启动配置替换全部设备
+func (r *registry) load(cfg *StartConfig[map[string]interface{}, map[string]interface{}, map[string]interface{}]) {
+	devices := map[string]map[string]*Device{}
+	list := make([]*Device, 0, len(cfg.Devices))
+	tags := map[*Device]map[string]entity.Tag{}
+	for i := range cfg.Devices {
+		d := &cfg.Devices[i]
+		if _, ok := devices[d.Table]; !ok {
+			devices[d.Table] = map[string]*Device{}
+		}
+		devices[d.Table][d.ID] = d
+		list = append(list, d)
+		tagM := make(map[string]entity.Tag, len(d.Tags))
+		for _, tag := range d.Tags {
+			tagM[tag.ID] = tag
+		}
+		tags[d] = tagM
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.devices = devices
+	r.list = list
+	r.tags = tags
+}
+
+// clear 清空全部设备
+func (r *registry) clear() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.devices = nil
+	r.list = nil
+	r.tags = nil
+}
+
+// get 查询设备,表id为空时按设备id查找,设备id在多个表中存在时返回false
+func (r *registry) get(table, id string) (*Device, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	if table != "" {
+		d, ok := r.devices[table][id]
+		return d, ok
+	}
+	var found *Device
+	for _, devices := range r.devices {
+		if d, ok := devices[id]; ok {
+			if found != nil {
+				return nil, false
+			}
+			found = d
+		}
+	}
+	return found, found != nil
+}
+
+func (r *registry) all() []*Device {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return append([]*Device{}, r.list...)
+}
+
+func (r *registry) tag(table, id, tagId string) (entity.Tag, bool) {
+	d, ok := r.get(table, id)
+	if !ok {
+		return entity.Tag{}, false
+	}
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	tag, ok := r.tags[d][tagId]
+	return tag, ok
+}
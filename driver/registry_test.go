@@ -0,0 +1,32 @@
+package driver
+
+import "testing"
+
+func TestRegistry(t *testing.T) {
+	cfg, err := DecodeStartConfig[map[string]interface{}, map[string]interface{}, map[string]interface{}]([]byte(`{
+	"tables": [
+		{"id": "t1", "device": {"tags": [{"id": "p1", "name": "模型p1"}]}, "devices": [{"id": "d1", "device": {"tags": [{"id": "p1", "name": "设备p1"}]}}, {"id": "d2"}]},
+		{"id": "t2", "devices": [{"id": "d2"}, {"id": "d3"}]}
+	]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r registry
+	r.load(cfg)
+	if n := len(r.all()); n != 4 {
+		t.Fatalf("设备数量错误,应为=4,实际为=%d", n)
+	}
+	if d, ok := r.get("", "d3"); !ok || d.Table != "t2" {
+		t.Fatalf("按设备id查询错误: %+v", d)
+	}
+	if _, ok := r.get("", "d2"); ok {
+		t.Fatal("设备id在多个表中存在时不应返回设备")
+	}
+	if tag, ok := r.tag("t1", "d1", "p1"); !ok || tag.Name != "设备p1" {
+		t.Fatalf("数据点查询错误: %+v", tag)
+	}
+	if tag, ok := r.tag("t1", "d2", "p1"); !ok || tag.Name != "模型p1" {
+		t.Fatalf("数据点查询错误: %+v", tag)
+	}
+}
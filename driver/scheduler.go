@@ -0,0 +1,247 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/air-iot/errors"
+	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// PollFunc 采集回调,tags为本次到期需要采集的数据点,没有数据点的设备tags为空
+type PollFunc func(ctx context.Context, device *Device, tags []entity.Tag) error
+
+// OverrunPolicy 上一次采集未完成时又到采集周期的处理策略
+type OverrunPolicy string
+
+const (
+	OverrunSkip  OverrunPolicy = "skip"  // 跳过本次采集
+	OverrunQueue OverrunPolicy = "queue" // 上一次采集完成后立即执行一次
+)
+
+// SchedulerConfig 轮询采集配置
+type SchedulerConfig struct {
+	Interval    time.Duration // 默认采集周期,没有数据点的设备按该周期采集
+	Jitter      time.Duration // 采集时间随机抖动,避免同时采集
+	Concurrency int           // 同一连接的最大并发采集数,默认为1
+	Overrun     OverrunPolicy // 默认为跳过
+	// IntervalFunc 数据点的采集周期,为空或返回值不大于0时使用默认采集周期
+	IntervalFunc func(device *Device, tag entity.Tag) time.Duration
+	// ConnectionFunc 设备使用的连接标识,相同标识的设备共享并发数,默认每个设备单独计算
+	ConnectionFunc func(device *Device) string
+}
+
+// Scheduler 按设备和数据点采集周期调用采集回调,新的启动配置到达时自动重启
+type Scheduler struct {
+	lock   sync.Mutex
+	cfg    SchedulerConfig
+	poll   PollFunc
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	report func(device *Device, err error)
+	// newTimer 创建采集定时器,测试时替换为手动推进的定时器
+	newTimer func(d time.Duration) pollTimer
+}
+
+// pollTimer 采集定时器
+type pollTimer interface {
+	C() <-chan time.Time
+	Reset(d time.Duration)
+	Stop()
+}
+
+type realTimer struct {
+	t *time.Timer
+}
+
+func newRealTimer(d time.Duration) pollTimer {
+	return realTimer{t: time.NewTimer(d)}
+}
+
+func (r realTimer) C() <-chan time.Time {
+	return r.t.C
+}
+
+func (r realTimer) Reset(d time.Duration) {
+	r.t.Reset(d)
+}
+
+func (r realTimer) Stop() {
+	r.t.Stop()
+}
+
+// pollGroup 同一设备相同采集周期的数据点
+type pollGroup struct {
+	device   *Device
+	interval time.Duration
+	tags     []entity.Tag
+	sem      chan struct{}
+}
+
+// NewScheduler 创建轮询采集
+func NewScheduler(cfg SchedulerConfig, poll PollFunc) *Scheduler {
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+	if cfg.Overrun == "" {
+		cfg.Overrun = OverrunSkip
+	}
+	return &Scheduler{cfg: cfg, poll: poll, newTimer: newRealTimer}
+}
+
+// Start 停止正在运行的采集并按传入的设备重新开始采集
+func (s *Scheduler) Start(devices []*Device) error {
+	if s.cfg.Interval <= 0 && s.cfg.IntervalFunc == nil {
+		return fmt.Errorf("采集周期未配置")
+	}
+	s.Stop()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	sems := map[string]chan struct{}{}
+	for _, device := range devices {
+		conn := device.Table + "/" + device.ID
+		if s.cfg.ConnectionFunc != nil {
+			conn = s.cfg.ConnectionFunc(device)
+		}
+		sem, ok := sems[conn]
+		if !ok {
+			sem = make(chan struct{}, s.cfg.Concurrency)
+			sems[conn] = sem
+		}
+		for _, g := range s.groups(device, sem) {
+			s.wg.Add(1)
+			go s.run(ctx, g)
+		}
+	}
+	return nil
+}
+
+// Stop 停止采集并等待正在执行的采集完成
+func (s *Scheduler) Stop() {
+	s.lock.Lock()
+	cancel := s.cancel
+	s.cancel = nil
+	s.lock.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	s.wg.Wait()
+}
+
+func (s *Scheduler) groups(device *Device, sem chan struct{}) []*pollGroup {
+	if len(device.Tags) == 0 {
+		// 没有数据点的设备按默认采集周期整体采集
+		if s.cfg.Interval <= 0 {
+			logger.Warnf("轮询采集: 设备表=%s,设备=%s. 设备没有数据点且默认采集周期未配置,不采集", device.Table, device.ID)
+			return nil
+		}
+		return []*pollGroup{{device: device, interval: s.cfg.Interval, sem: sem}}
+	}
+	groupM := map[time.Duration]*pollGroup{}
+	for _, tag := range device.Tags {
+		interval := s.cfg.Interval
+		if s.cfg.IntervalFunc != nil {
+			if i := s.cfg.IntervalFunc(device, tag); i > 0 {
+				interval = i
+			}
+		}
+		if interval <= 0 {
+			logger.Warnf("轮询采集: 设备表=%s,设备=%s,数据点=%s. 采集周期未配置,不采集", device.Table, device.ID, tag.ID)
+			continue
+		}
+		g, ok := groupM[interval]
+		if !ok {
+			g = &pollGroup{device: device, interval: interval, sem: sem}
+			groupM[interval] = g
+		}
+		g.tags = append(g.tags, tag)
+	}
+	groups := make([]*pollGroup, 0, len(groupM))
+	for _, g := range groupM {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].interval < groups[j].interval })
+	return groups
+}
+
+func (s *Scheduler) run(ctx context.Context, g *pollGroup) {
+	defer s.wg.Done()
+	done := make(chan struct{}, 1)
+	busy, queued := false, false
+	timer := s.newTimer(s.jitter())
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if busy {
+				<-done
+			}
+			return
+		case <-timer.C():
+			timer.Reset(g.interval + s.jitter())
+			if busy {
+				if s.cfg.Overrun == OverrunQueue {
+					queued = true
+				} else {
+					logger.Warnf("轮询采集: 设备表=%s,设备=%s,周期=%s. 上一次采集未完成,跳过本次采集", g.device.Table, g.device.ID, g.interval)
+				}
+				continue
+			}
+			busy = true
+			go s.pollGroup(ctx, g, done)
+		case <-done:
+			busy = false
+			if queued {
+				queued = false
+				busy = true
+				go s.pollGroup(ctx, g, done)
+			}
+		}
+	}
+}
+
+func (s *Scheduler) pollGroup(ctx context.Context, g *pollGroup, done chan<- struct{}) {
+	defer func() {
+		done <- struct{}{}
+	}()
+	select {
+	case g.sem <- struct{}{}:
+	case <-ctx.Done():
+		return
+	}
+	defer func() {
+		<-g.sem
+	}()
+	pollCtx := logger.NewTableContext(ctx, g.device.Table)
+	if Cfg.GroupID != "" {
+		pollCtx = logger.NewGroupContext(pollCtx, Cfg.GroupID)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logger.WithContext(pollCtx).Errorf("轮询采集: 设备表=%s,设备=%s. 采集异常: %+v", g.device.Table, g.device.ID, errors.WithStack(fmt.Errorf("%v", r)))
+		}
+	}()
+	err := s.poll(pollCtx, g.device, g.tags)
+	if err != nil {
+		errCtx := logger.NewErrorContext(pollCtx, err)
+		logger.WithContext(errCtx).Errorf("轮询采集: 设备表=%s,设备=%s. 采集错误", g.device.Table, g.device.ID)
+	}
+	if s.report != nil && ctx.Err() == nil {
+		s.report(g.device, err)
+	}
+}
+
+func (s *Scheduler) jitter() time.Duration {
+	if s.cfg.Jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.cfg.Jitter)))
+}
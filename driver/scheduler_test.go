@@ -0,0 +1,250 @@
+package driver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// fakeClock 手动推进时间的采集定时器
+type fakeClock struct {
+	lock   sync.Mutex
+	now    time.Duration
+	timers []*fakeTimer
+	resets chan struct{} // 每次创建或重置定时器时通知
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{resets: make(chan struct{}, 1000)}
+}
+
+type fakeTimer struct {
+	clock  *fakeClock
+	c      chan time.Time
+	at     time.Duration
+	active bool
+}
+
+func (c *fakeClock) newTimer(d time.Duration) pollTimer {
+	t := &fakeTimer{clock: c, c: make(chan time.Time, 1)}
+	c.lock.Lock()
+	c.timers = append(c.timers, t)
+	c.lock.Unlock()
+	t.Reset(d)
+	return t
+}
+
+// advance 推进时间,触发到期的定时器
+func (c *fakeClock) advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.now += d
+	for _, t := range c.timers {
+		if t.active && t.at <= c.now {
+			t.active = false
+			select {
+			case t.c <- time.Time{}:
+			default:
+			}
+		}
+	}
+}
+
+// waitResets 等待n次定时器创建或重置,即采集协程已处理完到期的定时器
+func (c *fakeClock) waitResets(t *testing.T, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-c.resets:
+		case <-time.After(time.Second * 5):
+			t.Fatalf("等待定时器重置超时,应为=%d次,实际为=%d次", n, i)
+		}
+	}
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Reset(d time.Duration) {
+	t.clock.lock.Lock()
+	t.at = t.clock.now + d
+	t.active = true
+	t.clock.lock.Unlock()
+	t.clock.resets <- struct{}{}
+}
+
+func (t *fakeTimer) Stop() {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+	t.active = false
+}
+
+// waitPolls 等待n次采集
+func waitPolls(t *testing.T, polls <-chan string, n int) []string {
+	t.Helper()
+	res := make([]string, 0, n)
+	for len(res) < n {
+		select {
+		case p := <-polls:
+			res = append(res, p)
+		case <-time.After(time.Second * 5):
+			t.Fatalf("等待采集超时,应为=%d次,实际为=%v", n, res)
+		}
+	}
+	return res
+}
+
+func TestScheduler_Interval(t *testing.T) {
+	clock := newFakeClock()
+	polls := make(chan string, 100)
+	s := NewScheduler(SchedulerConfig{
+		Interval: time.Millisecond * 100,
+		Overrun:  OverrunQueue,
+		IntervalFunc: func(device *Device, tag entity.Tag) time.Duration {
+			if tag.ID == "fast" {
+				return time.Millisecond * 10
+			}
+			return 0
+		},
+	}, func(ctx context.Context, device *Device, tags []entity.Tag) error {
+		ids := make([]string, 0, len(tags))
+		for _, tag := range tags {
+			ids = append(ids, tag.ID)
+		}
+		polls <- device.ID + ":" + strings.Join(ids, ",")
+		return nil
+	})
+	s.newTimer = clock.newTimer
+	devices := []*Device{
+		{Table: "t1", ID: "d1", Tags: []entity.Tag{{ID: "fast"}, {ID: "slow"}}},
+		// 没有数据点的设备按默认采集周期采集
+		{Table: "t1", ID: "d2"},
+	}
+	if err := s.Start(devices); err != nil {
+		t.Fatal(err)
+	}
+	clock.waitResets(t, 3)
+	counts := map[string]int{}
+	for i := 0; i <= 10; i++ {
+		n := 1
+		if i%10 == 0 {
+			n = 3
+		}
+		if i > 0 {
+			clock.advance(time.Millisecond * 10)
+		} else {
+			clock.advance(0)
+		}
+		for _, p := range waitPolls(t, polls, n) {
+			counts[p]++
+		}
+	}
+	s.Stop()
+	if len(polls) != 0 {
+		t.Fatalf("采集次数超出预期: %v,剩余=%d", counts, len(polls))
+	}
+	want := map[string]int{"d1:fast": 11, "d1:slow": 2, "d2:": 2}
+	for k, v := range want {
+		if counts[k] != v {
+			t.Fatalf("采集次数不匹配,应为=%v,实际为=%v", want, counts)
+		}
+	}
+}
+
+func TestScheduler_Overrun(t *testing.T) {
+	for policy, want := range map[OverrunPolicy]int{OverrunSkip: 1, OverrunQueue: 2} {
+		clock := newFakeClock()
+		polls := make(chan string, 10)
+		release := make(chan struct{})
+		s := NewScheduler(SchedulerConfig{Interval: time.Millisecond * 10, Overrun: policy},
+			func(ctx context.Context, device *Device, tags []entity.Tag) error {
+				polls <- device.ID
+				<-release
+				return nil
+			})
+		s.newTimer = clock.newTimer
+		if err := s.Start([]*Device{{Table: "t1", ID: "d1", Tags: []entity.Tag{{ID: "t"}}}}); err != nil {
+			t.Fatal(err)
+		}
+		clock.waitResets(t, 1)
+		clock.advance(0)
+		clock.waitResets(t, 1)
+		waitPolls(t, polls, 1)
+		// 采集未完成时又到两次采集周期
+		for i := 0; i < 2; i++ {
+			clock.advance(time.Millisecond * 10)
+			clock.waitResets(t, 1)
+		}
+		close(release)
+		n := 1
+		if policy == OverrunQueue {
+			// 上一次采集完成后补采一次
+			n += len(waitPolls(t, polls, 1))
+		}
+		s.Stop()
+		if n += len(polls); n != want {
+			t.Fatalf("策略=%s,采集次数不匹配,应为=%d,实际为=%d", policy, want, n)
+		}
+	}
+}
+
+func TestScheduler_Concurrency(t *testing.T) {
+	clock := newFakeClock()
+	polls := make(chan string, 10)
+	release := make(chan struct{})
+	var running, max int32
+	s := NewScheduler(SchedulerConfig{
+		Interval:       time.Millisecond * 5,
+		Concurrency:    1,
+		ConnectionFunc: func(device *Device) string { return "conn" },
+	}, func(ctx context.Context, device *Device, tags []entity.Tag) error {
+		cur := atomic.AddInt32(&running, 1)
+		for {
+			old := atomic.LoadInt32(&max)
+			if cur <= old || atomic.CompareAndSwapInt32(&max, old, cur) {
+				break
+			}
+		}
+		polls <- device.ID
+		<-release
+		atomic.AddInt32(&running, -1)
+		return nil
+	})
+	s.newTimer = clock.newTimer
+	devices := []*Device{
+		{Table: "t1", ID: "d1", Tags: []entity.Tag{{ID: "t"}}},
+		{Table: "t1", ID: "d2", Tags: []entity.Tag{{ID: "t"}}},
+		{Table: "t1", ID: "d3", Tags: []entity.Tag{{ID: "t"}}},
+	}
+	if err := s.Start(devices); err != nil {
+		t.Fatal(err)
+	}
+	clock.waitResets(t, 3)
+	clock.advance(0)
+	clock.waitResets(t, 3)
+	// 三个设备共享连接,依次采集
+	for i := 0; i < 3; i++ {
+		waitPolls(t, polls, 1)
+		release <- struct{}{}
+	}
+	// 重新启动后继续采集
+	if err := s.Start(devices[:1]); err != nil {
+		t.Fatal(err)
+	}
+	clock.waitResets(t, 1)
+	clock.advance(0)
+	if p := waitPolls(t, polls, 1); p[0] != "d1" {
+		t.Fatalf("重新启动后应采集设备=d1,实际为=%s", p[0])
+	}
+	release <- struct{}{}
+	s.Stop()
+	if m := atomic.LoadInt32(&max); m != 1 {
+		t.Fatalf("同一连接并发数应为1,实际为=%d", m)
+	}
+}
@@ -0,0 +1,277 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/air-iot/json"
+	"github.com/air-iot/logger"
+)
+
+// DeviceStatus 设备在线状态
+type DeviceStatus string
+
+const (
+	StatusOnline  DeviceStatus = "online"  // 在线
+	StatusOffline DeviceStatus = "offline" // 离线
+)
+
+// StatusConfig 设备在线状态配置
+type StatusConfig struct {
+	Enable      bool          `json:"enable" yaml:"enable"`           // 根据数据和采集结果自动判断在线状态
+	Field       string        `json:"field" yaml:"field"`             // 表数据中保存在线状态的字段
+	MaxFailures int           `json:"maxFailures" yaml:"maxFailures"` // 连续采集失败次数,达到后离线
+	Timeout     time.Duration `json:"timeout" yaml:"timeout"`         // 未收到数据的超时时间,超时后离线
+	Debounce    time.Duration `json:"debounce" yaml:"debounce"`       // 启用自动判断时,状态变化保持该时间后才上报,防止状态频繁变化
+}
+
+// StatusChange 设备在线状态变化消息
+type StatusChange struct {
+	Table    string       `json:"table"`
+	ID       string       `json:"id"`
+	Status   DeviceStatus `json:"status"`
+	Reason   string       `json:"reason"`
+	UnixTime int64        `json:"time"`
+}
+
+type commitStatusFunc func(ctx context.Context, table, id string, status DeviceStatus, reason string) error
+
+// deviceState 设备在线状态
+type deviceState struct {
+	table         string
+	id            string
+	status        DeviceStatus // 已上报的状态
+	pending       DeviceStatus // 等待上报的状态
+	pendingReason string
+	pendingSince  time.Time
+	failures      int
+	lastSeen      time.Time
+}
+
+// statusTracker 设备在线状态跟踪,状态变化时上报
+type statusTracker struct {
+	cfg     StatusConfig
+	commit  commitStatusFunc
+	lock    sync.Mutex
+	devices map[string]*deviceState
+}
+
+func newStatusTracker(cfg StatusConfig, commit commitStatusFunc) *statusTracker {
+	if cfg.Field == "" {
+		cfg.Field = "status"
+	}
+	return &statusTracker{cfg: cfg, commit: commit, devices: map[string]*deviceState{}}
+}
+
+// get 查询设备已上报的状态,未上报过时为空
+func (t *statusTracker) get(table, id string) DeviceStatus {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if st, ok := t.devices[table+"/"+id]; ok {
+		return st.status
+	}
+	return ""
+}
+
+// set 设置设备状态,需要上报时同步上报
+func (t *statusTracker) set(ctx context.Context, table, id string, status DeviceStatus, reason string) error {
+	t.lock.Lock()
+	st := t.state(table, id)
+	if status == StatusOnline {
+		st.lastSeen = time.Now()
+		st.failures = 0
+	}
+	commit := t.transition(st, status, reason, time.Now())
+	t.lock.Unlock()
+	if !commit {
+		return nil
+	}
+	return t.commit(ctx, table, id, status, reason)
+}
+
+// observe 收到设备数据或采集结果,需要上报时异步上报
+func (t *statusTracker) observe(table, id string, err error) {
+	t.lock.Lock()
+	st := t.state(table, id)
+	status, reason := StatusOnline, ""
+	if err == nil {
+		st.lastSeen = time.Now()
+		st.failures = 0
+	} else {
+		st.failures++
+		if t.cfg.MaxFailures <= 0 || st.failures < t.cfg.MaxFailures {
+			t.lock.Unlock()
+			return
+		}
+		status, reason = StatusOffline, fmt.Sprintf("连续%d次采集失败: %v", st.failures, err)
+	}
+	commit := t.transition(st, status, reason, time.Now())
+	t.lock.Unlock()
+	if commit {
+		go t.doCommit(table, id, status, reason)
+	}
+}
+
+// reset 收到新的启动配置,删除已不在配置中的设备并重新计算超时时间
+func (t *statusTracker) reset(devices []*Device) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	now := time.Now()
+	newDevices := make(map[string]*deviceState, len(devices))
+	for _, device := range devices {
+		key := device.Table + "/" + device.ID
+		st, ok := t.devices[key]
+		if !ok {
+			st = &deviceState{table: device.Table, id: device.ID}
+		}
+		st.lastSeen = now
+		newDevices[key] = st
+	}
+	t.devices = newDevices
+}
+
+// check 检查超时未收到数据的设备和等待上报的状态
+func (t *statusTracker) check(now time.Time) {
+	changes := make([]StatusChange, 0)
+	t.lock.Lock()
+	for _, st := range t.devices {
+		if t.cfg.Timeout > 0 && st.status != StatusOffline && st.pending != StatusOffline && now.Sub(st.lastSeen) >= t.cfg.Timeout {
+			if t.transition(st, StatusOffline, fmt.Sprintf("超过%s未收到数据", t.cfg.Timeout), now) {
+				changes = append(changes, StatusChange{Table: st.table, ID: st.id, Status: StatusOffline, Reason: st.pendingReason})
+				continue
+			}
+		}
+		if st.pending != "" && now.Sub(st.pendingSince) >= t.cfg.Debounce {
+			changes = append(changes, StatusChange{Table: st.table, ID: st.id, Status: st.pending, Reason: st.pendingReason})
+			st.status = st.pending
+			st.pending = ""
+		}
+	}
+	t.lock.Unlock()
+	for _, c := range changes {
+		t.doCommit(c.Table, c.ID, c.Status, c.Reason)
+	}
+}
+
+// transition 状态变化时进入等待上报,返回是否需要立即上报.
+// 首次上报、未配置防抖或未启用自动判断时立即上报,防抖只在自动判断时由run定时提交
+func (t *statusTracker) transition(st *deviceState, status DeviceStatus, reason string, now time.Time) bool {
+	if st.status == status {
+		st.pending = ""
+		return false
+	}
+	st.pendingReason = reason
+	if st.status == "" || t.cfg.Debounce <= 0 || !t.cfg.Enable {
+		st.status = status
+		st.pending = ""
+		return true
+	}
+	if st.pending != status {
+		st.pending = status
+		st.pendingSince = now
+	}
+	return false
+}
+
+func (t *statusTracker) state(table, id string) *deviceState {
+	key := table + "/" + id
+	st, ok := t.devices[key]
+	if !ok {
+		st = &deviceState{table: table, id: id, lastSeen: time.Now()}
+		t.devices[key] = st
+	}
+	return st
+}
+
+func (t *statusTracker) doCommit(table, id string, status DeviceStatus, reason string) {
+	ctx, cancel := context.WithTimeout(context.Background(), Cfg.DriverGrpc.Timeout)
+	defer cancel()
+	if err := t.commit(ctx, table, id, status, reason); err != nil {
+		logger.Errorf("设备状态: 设备表=%s,设备=%s,状态=%s. 上报错误: %v", table, id, status, err)
+	}
+}
+
+// run 定时检查设备状态
+func (t *statusTracker) run(ctx context.Context) {
+	interval := time.Second
+	for _, d := range []time.Duration{t.cfg.Timeout / 2, t.cfg.Debounce / 2} {
+		if d > 0 && d < interval {
+			interval = d
+		}
+	}
+	if interval < time.Millisecond*100 {
+		interval = time.Millisecond * 100
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			t.check(now)
+		}
+	}
+}
+
+// commitStatus 发送设备状态变化消息并更新表数据中的状态字段
+func (a *app) commitStatus(ctx context.Context, table, id string, status DeviceStatus, reason string) error {
+	logger.Infof("设备状态: 设备表=%s,设备=%s,状态=%s,原因=%s", table, id, status, reason)
+	b, err := json.Marshal(&StatusChange{Table: table, ID: id, Status: status, Reason: reason, UnixTime: time.Now().Local().UnixMilli()})
+	if err != nil {
+		return err
+	}
+	if err := a.mq.Publish(ctx, []string{"status", Cfg.Project, table, id}, b); err != nil {
+		return fmt.Errorf("发送设备状态错误: %w", err)
+	}
+	if a.cli == nil {
+		return nil
+	}
+	if err := a.UpdateTableData(ctx, table, id, map[string]interface{}{a.status.cfg.Field: string(status)}); err != nil {
+		return fmt.Errorf("更新设备状态错误: %w", err)
+	}
+	return nil
+}
+
+// SetDeviceOnline 设置设备在线
+func (a *app) SetDeviceOnline(ctx context.Context, table, id string) error {
+	table, err := a.statusTable(table, id)
+	if err != nil {
+		return err
+	}
+	return a.status.set(ctx, table, id, StatusOnline, "")
+}
+
+// SetDeviceOffline 设置设备离线
+func (a *app) SetDeviceOffline(ctx context.Context, table, id, reason string) error {
+	table, err := a.statusTable(table, id)
+	if err != nil {
+		return err
+	}
+	return a.status.set(ctx, table, id, StatusOffline, reason)
+}
+
+// GetDeviceStatus 查询设备已上报的在线状态,未上报过时为空
+func (a *app) GetDeviceStatus(table, id string) DeviceStatus {
+	table, err := a.statusTable(table, id)
+	if err != nil {
+		return ""
+	}
+	return a.status.get(table, id)
+}
+
+func (a *app) statusTable(table, id string) (string, error) {
+	if id == "" {
+		return "", fmt.Errorf("设备id为空")
+	}
+	if table != "" {
+		return table, nil
+	}
+	device, ok := a.GetDevice("", id)
+	if !ok {
+		return "", fmt.Errorf("传入表id为空且未在配置中找到")
+	}
+	return device.Table, nil
+}
@@ -0,0 +1,98 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+type statusRecorder struct {
+	lock    sync.Mutex
+	changes []DeviceStatus
+}
+
+func (r *statusRecorder) commit(ctx context.Context, table, id string, status DeviceStatus, reason string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.changes = append(r.changes, status)
+	return nil
+}
+
+func (r *statusRecorder) get() []DeviceStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]DeviceStatus{}, r.changes...)
+}
+
+func TestStatusTracker_Debounce(t *testing.T) {
+	r := new(statusRecorder)
+	tracker := newStatusTracker(StatusConfig{Enable: true, Debounce: time.Millisecond * 50}, r.commit)
+	ctx := context.Background()
+	if err := tracker.set(ctx, "t1", "d1", StatusOnline, ""); err != nil {
+		t.Fatal(err)
+	}
+	// 防抖时间内恢复在线,不上报离线
+	_ = tracker.set(ctx, "t1", "d1", StatusOffline, "test")
+	_ = tracker.set(ctx, "t1", "d1", StatusOnline, "")
+	tracker.check(time.Now().Add(time.Millisecond * 100))
+	if changes := r.get(); len(changes) != 1 {
+		t.Fatalf("状态上报次数应为=1,实际为=%v", changes)
+	}
+	_ = tracker.set(ctx, "t1", "d1", StatusOffline, "test")
+	tracker.check(time.Now())
+	if tracker.get("t1", "d1") != StatusOnline {
+		t.Fatal("防抖时间内状态不应变化")
+	}
+	tracker.check(time.Now().Add(time.Millisecond * 100))
+	if changes := r.get(); len(changes) != 2 || changes[1] != StatusOffline {
+		t.Fatalf("状态上报不匹配: %v", changes)
+	}
+}
+
+func TestStatusTracker_Manual(t *testing.T) {
+	cfg, err := LoadConfig(WithConfigPath(t.TempDir()), WithConfigOptional())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := new(statusRecorder)
+	tracker := newStatusTracker(cfg.Status, r.commit)
+	ctx := context.Background()
+	for _, status := range []DeviceStatus{StatusOnline, StatusOffline, StatusOnline} {
+		if err := tracker.set(ctx, "t1", "d1", status, ""); err != nil {
+			t.Fatal(err)
+		}
+		if got := tracker.get("t1", "d1"); got != status {
+			t.Fatalf("默认配置下状态应立即上报,应为=%s,实际为=%s", status, got)
+		}
+	}
+	if changes := r.get(); len(changes) != 3 {
+		t.Fatalf("状态上报次数应为=3,实际为=%v", changes)
+	}
+}
+
+func TestStatusTracker_Auto(t *testing.T) {
+	r := new(statusRecorder)
+	tracker := newStatusTracker(StatusConfig{Enable: true, MaxFailures: 2, Timeout: time.Second}, r.commit)
+	tracker.reset([]*Device{{Table: "t1", ID: "d1"}, {Table: "t1", ID: "d2"}})
+	tracker.observe("t1", "d1", nil)
+	failed := errors.New("timeout")
+	tracker.observe("t1", "d1", failed)
+	if tracker.get("t1", "d1") != StatusOnline {
+		t.Fatal("未达到失败次数时应保持在线")
+	}
+	tracker.observe("t1", "d1", failed)
+	if tracker.get("t1", "d1") != StatusOffline {
+		t.Fatal("达到失败次数时应离线")
+	}
+	// d2 超时未收到数据
+	tracker.check(time.Now().Add(time.Second * 2))
+	if tracker.get("t1", "d2") != StatusOffline {
+		t.Fatal("超时未收到数据时应离线")
+	}
+	time.Sleep(time.Millisecond * 50)
+	if changes := r.get(); len(changes) != 3 {
+		t.Fatalf("状态上报次数应为=3,实际为=%v", changes)
+	}
+}
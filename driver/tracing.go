@@ -0,0 +1,215 @@
+package driver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/air-iot/json"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const tracerName = "github.com/air-iot/sdk-go/v4/driver"
+
+// TracingConfig 链路追踪配置
+type TracingConfig struct {
+	Enable   bool   `json:"enable" yaml:"enable"`
+	Exporter string `json:"exporter" yaml:"exporter"` // 导出方式 stdout或file
+	File     string `json:"file" yaml:"file"`         // 导出文件路径,OTLP JSON文件格式,每行一批span
+	// SampleRatio 采样比例,0到1,默认1全部采样.为0时不主动采样,只跟随驱动管理传递的采样决定
+	SampleRatio *float64 `json:"sampleRatio" yaml:"sampleRatio"`
+}
+
+// initTracing 初始化链路追踪,返回停止函数
+func initTracing(cfg TracingConfig) (func(), error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Exporter {
+	case "", "stdout":
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case "file":
+		if cfg.File == "" {
+			return nil, fmt.Errorf("链路追踪导出文件路径为空")
+		}
+		f, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("打开链路追踪导出文件错误: %w", err)
+		}
+		exporter = otlptrace.NewUnstarted(&otlpFileClient{file: f})
+	default:
+		return nil, fmt.Errorf("不支持的链路追踪导出方式: %s", cfg.Exporter)
+	}
+	ratio := 1.0
+	if cfg.SampleRatio != nil {
+		ratio = *cfg.SampleRatio
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(Cfg.ServiceID),
+		attribute.String("driver.id", Cfg.Driver.ID),
+		attribute.String("driver.name", Cfg.Driver.Name),
+		attribute.String("project", Cfg.Project),
+	)
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "链路追踪停止错误: %v\n", err)
+		}
+	}, nil
+}
+
+// otlpFileClient 按OTLP JSON文件格式写入span,每批span写为一行TracesData
+type otlpFileClient struct {
+	lock sync.Mutex
+	file *os.File
+}
+
+func (c *otlpFileClient) Start(context.Context) error {
+	return nil
+}
+
+func (c *otlpFileClient) Stop(context.Context) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.file.Close()
+}
+
+func (c *otlpFileClient) UploadTraces(_ context.Context, spans []*tracepb.ResourceSpans) error {
+	b, err := marshalOTLPJSON(&tracepb.TracesData{ResourceSpans: spans})
+	if err != nil {
+		return err
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, err = c.file.Write(append(b, '\n'))
+	return err
+}
+
+// marshalOTLPJSON OTLP JSON编码,与protojson的区别为枚举使用数值,traceId、spanId使用十六进制而不是base64
+func marshalOTLPJSON(data *tracepb.TracesData) ([]byte, error) {
+	b, err := protojson.MarshalOptions{UseEnumNumbers: true}.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	hexIDs(v)
+	return json.Marshal(v)
+}
+
+// hexIDs 将traceId、spanId、parentSpanId由base64转换为十六进制
+func hexIDs(v interface{}) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, item := range val {
+			switch k {
+			case "traceId", "spanId", "parentSpanId":
+				if s, ok := item.(string); ok {
+					if id, err := base64.StdEncoding.DecodeString(s); err == nil {
+						val[k] = hex.EncodeToString(id)
+					}
+				}
+			default:
+				hexIDs(item)
+			}
+		}
+	case []interface{}:
+		for _, item := range val {
+			hexIDs(item)
+		}
+	}
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// startCommandSpan 开始指令处理的span,驱动管理请求metadata中的链路信息作为父span
+func startCommandSpan(ctx context.Context, md metadata.MD, name, table, id, serialNo string) (context.Context, trace.Span) {
+	ctx = otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+	return tracer().Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("table", table),
+			attribute.String("device", id),
+			attribute.String("serialNo", serialNo),
+		))
+}
+
+// endSpan 结束span,有错误时记录错误
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// metadataCarrier gRPC metadata传递链路信息
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// tracingUnaryInterceptor 为驱动管理的请求创建span并通过metadata传递链路信息,不追踪健康检查
+func tracingUnaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if strings.HasSuffix(method, "/HealthCheck") || !trace.SpanFromContext(ctx).SpanContext().IsValid() {
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+	ctx, span := tracer().Start(ctx, strings.TrimPrefix(method, "/"), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.RPCSystemGRPC, attribute.String("rpc.method", method)))
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	err := invoker(metadata.NewOutgoingContext(ctx, md), method, req, reply, cc, opts...)
+	endSpan(span, err)
+	return err
+}
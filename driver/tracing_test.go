@@ -0,0 +1,174 @@
+package driver
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	pb "github.com/air-iot/api-client-go/v4/driver"
+	"github.com/air-iot/json"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+
+	"github.com/air-iot/sdk-go/v4/conn/mq"
+)
+
+func TestTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	memory, clean, err := mq.NewMemoryClient(mq.MemoryConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clean()
+	a := &app{mq: memory}
+	ctx, span := startCommandSpan(context.Background(), nil, "driver.Run", "t1", "d1", "s1")
+	if err := a.publish(ctx, []string{"data", "p", "t1", "d1"}, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	var md metadata.MD
+	err = tracingUnaryInterceptor(ctx, "/driver.DriverService/FindTableData", nil, nil, nil,
+		func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, opts ...grpc.CallOption) error {
+			md, _ = metadata.FromOutgoingContext(ctx)
+			return errors.New("unavailable")
+		})
+	if err == nil {
+		t.Fatal("应返回请求错误")
+	}
+	if len(md.Get("traceparent")) == 0 {
+		t.Fatal("metadata中应包含链路信息")
+	}
+	endSpan(span, nil)
+
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("span数量应为=3,实际为=%d", len(spans))
+	}
+	root := spans[2]
+	if root.Name() != "driver.Run" {
+		t.Fatalf("根span名称不匹配: %s", root.Name())
+	}
+	for _, s := range spans[:2] {
+		if s.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatalf("span %s 的父span不匹配", s.Name())
+		}
+	}
+	if spans[1].Status().Description != "unavailable" {
+		t.Fatalf("请求错误未记录: %+v", spans[1].Status())
+	}
+}
+
+func TestTracingFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "trace.json")
+	shutdown, err := initTracing(TracingConfig{Enable: true, Exporter: "file", File: file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, span := startCommandSpan(context.Background(), nil, "driver.Run", "t1", "d1", "s1")
+	endSpan(span, nil)
+	shutdown()
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data struct {
+		ResourceSpans []struct {
+			ScopeSpans []struct {
+				Spans []struct {
+					TraceID string `json:"traceId"`
+					SpanID  string `json:"spanId"`
+					Name    string `json:"name"`
+					Kind    int    `json:"kind"`
+				} `json:"spans"`
+			} `json:"scopeSpans"`
+		} `json:"resourceSpans"`
+	}
+	if err := json.Unmarshal(b, &data); err != nil {
+		t.Fatalf("导出文件应为OTLP JSON格式: %v", err)
+	}
+	if len(data.ResourceSpans) != 1 || len(data.ResourceSpans[0].ScopeSpans) != 1 || len(data.ResourceSpans[0].ScopeSpans[0].Spans) != 1 {
+		t.Fatalf("导出span数量不匹配: %s", b)
+	}
+	s := data.ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if s.Name != "driver.Run" || s.Kind != int(trace.SpanKindServer) {
+		t.Fatalf("span不匹配: %+v", s)
+	}
+	if s.TraceID != span.SpanContext().TraceID().String() || s.SpanID != span.SpanContext().SpanID().String() {
+		t.Fatalf("traceId、spanId应为十六进制: %+v", s)
+	}
+}
+
+func TestTracing_SampleRatio(t *testing.T) {
+	var zero float64
+	shutdown, err := initTracing(TracingConfig{Enable: true, Exporter: "file", File: filepath.Join(t.TempDir(), "trace.json"), SampleRatio: &zero})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown()
+	_, span := startCommandSpan(context.Background(), nil, "driver.Run", "t1", "d1", "s1")
+	endSpan(span, nil)
+	if span.SpanContext().IsSampled() {
+		t.Error("采样比例为0时不应采样")
+	}
+	// 驱动管理已采样时跟随上游的采样决定
+	md := metadata.Pairs("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, span = startCommandSpan(context.Background(), md, "driver.Run", "t1", "d1", "s1")
+	endSpan(span, nil)
+	if !span.SpanContext().IsSampled() {
+		t.Error("上游已采样时应采样")
+	}
+}
+
+func TestTracing_RunStream(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	const traceId, parentId = "0af7651916cd43dd8448eb211c80319c", "b7ad6b7169203331"
+	stream := &fakeRunStream{
+		header:   metadata.Pairs("traceparent", "00-"+traceId+"-"+parentId+"-01"),
+		requests: make(chan *pb.RunRequest, 1),
+		results:  make(chan *pb.RunResult, 1),
+	}
+	d := &recordingDriver{runs: make(chan trace.SpanContext, 1)}
+	c := &Client{cli: &fakeDriverServiceClient{run: stream}, driver: d}
+	done := make(chan error, 1)
+	go func() {
+		done <- c.RunStream(context.Background())
+	}()
+	stream.requests <- &pb.RunRequest{Request: "1", TableId: "t1", Id: "d1", SerialNo: "s1", Command: []byte("{}")}
+	var sc trace.SpanContext
+	select {
+	case sc = <-d.runs:
+	case <-time.After(time.Second * 5):
+		t.Fatal("未执行指令")
+	}
+	select {
+	case <-stream.results:
+	case <-time.After(time.Second * 5):
+		t.Fatal("未返回指令结果")
+	}
+	close(stream.requests)
+	<-done
+	if sc.TraceID().String() != traceId {
+		t.Errorf("traceId应为=%s,实际为=%s", traceId, sc.TraceID())
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 {
+		t.Fatalf("span数量应为=1,实际为=%d", len(spans))
+	}
+	if got := spans[0].Parent().SpanID().String(); got != parentId || !spans[0].Parent().IsRemote() {
+		t.Errorf("父span应为驱动管理的span=%s,实际为=%s", parentId, got)
+	}
+}
@@ -0,0 +1,299 @@
+package driver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/air-iot/json"
+
+	"github.com/air-iot/sdk-go/v4/driver/entity"
+)
+
+// TypedDriver 使用强类型配置的驱动,I为实例配置,T为模型配置,D为设备配置.
+// 除Start外其余函数与Driver相同
+type TypedDriver[I, T, D any] interface {
+	Schema(ctx context.Context, app App) (schema string, err error)
+
+	// Start
+	// @description 驱动启动
+	// @param config "解析后的实例配置及设备列表"
+	Start(ctx context.Context, app App, config *StartConfig[I, T, D]) (err error)
+
+	Run(ctx context.Context, app App, command *entity.Command) (result interface{}, err error)
+	BatchRun(ctx context.Context, app App, command *entity.BatchCommand) (result interface{}, err error)
+	WriteTag(ctx context.Context, app App, command *entity.Command) (result interface{}, err error)
+	Debug(ctx context.Context, app App, debugConfig []byte) (result interface{}, err error)
+	HttpProxy(ctx context.Context, app App, t string, header http.Header, data []byte) (result interface{}, err error)
+	Stop(ctx context.Context, app App) (err error)
+}
+
+// Validator 配置校验,实例、模型或设备配置实现该接口时在启动前校验
+type Validator interface {
+	Validate() error
+}
+
+// StartConfig 解析后的驱动启动配置
+type StartConfig[I, T, D any] struct {
+	ID         string               // 实例id
+	Name       string               // 实例名称
+	DriverType string               // 驱动类型
+	GroupID    string               // 组id
+	Debug      *bool                // 是否开启调试日志
+	Settings   I                    // 实例配置
+	Tables     []TableConfig[T]     // 模型配置
+	Devices    []DeviceConfig[T, D] // 设备列表,设备配置已合并模型配置
+	Raw        []byte               // 原始配置
+}
+
+// TableConfig 模型配置
+type TableConfig[T any] struct {
+	ID       string                 // 模型id
+	Settings T                      // 模型驱动配置
+	Tags     []entity.Tag           // 模型数据点
+	Commands []entity.CommandConfig // 模型指令
+}
+
+// DeviceConfig 合并模型配置后的设备配置
+type DeviceConfig[T, D any] struct {
+	Table         string                 // 模型id
+	ID            string                 // 设备id
+	Name          string                 // 设备名称
+	TableSettings T                      // 所属模型配置
+	Settings      D                      // 设备配置,设备未配置的字段使用模型配置
+	Tags          []entity.Tag           // 数据点,设备数据点覆盖同标识的模型数据点
+	Commands      []entity.CommandConfig // 指令,设备指令覆盖同标识的模型指令
+}
+
+// Typed 将强类型配置的驱动转换为Driver,启动时解析并校验配置
+func Typed[I, T, D any](d TypedDriver[I, T, D]) Driver {
+	return &typedDriver[I, T, D]{d: d}
+}
+
+type typedDriver[I, T, D any] struct {
+	d TypedDriver[I, T, D]
+}
+
+func (t *typedDriver[I, T, D]) Schema(ctx context.Context, app App) (string, error) {
+	return t.d.Schema(ctx, app)
+}
+
+func (t *typedDriver[I, T, D]) Start(ctx context.Context, app App, driverConfig []byte) error {
+	cfg, err := DecodeStartConfig[I, T, D](driverConfig)
+	if err != nil {
+		return err
+	}
+	return t.d.Start(ctx, app, cfg)
+}
+
+func (t *typedDriver[I, T, D]) Run(ctx context.Context, app App, command *entity.Command) (interface{}, error) {
+	return t.d.Run(ctx, app, command)
+}
+
+func (t *typedDriver[I, T, D]) BatchRun(ctx context.Context, app App, command *entity.BatchCommand) (interface{}, error) {
+	return t.d.BatchRun(ctx, app, command)
+}
+
+func (t *typedDriver[I, T, D]) WriteTag(ctx context.Context, app App, command *entity.Command) (interface{}, error) {
+	return t.d.WriteTag(ctx, app, command)
+}
+
+func (t *typedDriver[I, T, D]) Debug(ctx context.Context, app App, debugConfig []byte) (interface{}, error) {
+	return t.d.Debug(ctx, app, debugConfig)
+}
+
+func (t *typedDriver[I, T, D]) HttpProxy(ctx context.Context, app App, typ string, header http.Header, data []byte) (interface{}, error) {
+	return t.d.HttpProxy(ctx, app, typ, header, data)
+}
+
+func (t *typedDriver[I, T, D]) Stop(ctx context.Context, app App) error {
+	return t.d.Stop(ctx, app)
+}
+
+// rawInstance 驱动管理下发的实例配置
+type rawInstance struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	DriverType string     `json:"driverType"`
+	GroupID    string     `json:"groupId"`
+	Debug      *bool      `json:"debug"`
+	Device     rawDevice  `json:"device"`
+	Tables     []rawTable `json:"tables"`
+}
+
+type rawTable struct {
+	ID      string    `json:"id"`
+	Device  rawDevice `json:"device"`
+	Devices []struct {
+		ID     string    `json:"id"`
+		Name   string    `json:"name"`
+		Device rawDevice `json:"device"`
+	} `json:"devices"`
+}
+
+type rawDevice struct {
+	Settings map[string]interface{}   `json:"settings"`
+	Tags     []entity.Tag             `json:"tags"`
+	Commands []map[string]interface{} `json:"commands"`
+}
+
+// DecodeStartConfig 解析驱动启动配置,合并模型和设备的配置、数据点及指令并校验
+func DecodeStartConfig[I, T, D any](bts []byte) (*StartConfig[I, T, D], error) {
+	var raw rawInstance
+	if err := json.Unmarshal(bts, &raw); err != nil {
+		return nil, fmt.Errorf("解析驱动配置错误: %w", err)
+	}
+	cfg := &StartConfig[I, T, D]{
+		ID:         raw.ID,
+		Name:       raw.Name,
+		DriverType: raw.DriverType,
+		GroupID:    raw.GroupID,
+		Debug:      raw.Debug,
+		Tables:     make([]TableConfig[T], 0, len(raw.Tables)),
+		Devices:    make([]DeviceConfig[T, D], 0),
+		Raw:        bts,
+	}
+	if err := decodeSettings(raw.Device.Settings, &cfg.Settings); err != nil {
+		return nil, fmt.Errorf("解析实例配置错误: %w", err)
+	}
+	if err := validate(&cfg.Settings); err != nil {
+		return nil, fmt.Errorf("实例配置校验错误: %w", err)
+	}
+	tableIds := map[string]struct{}{}
+	for _, t := range raw.Tables {
+		if t.ID == "" {
+			return nil, fmt.Errorf("模型id为空")
+		}
+		if _, ok := tableIds[t.ID]; ok {
+			return nil, fmt.Errorf("模型=%s. 模型id重复", t.ID)
+		}
+		tableIds[t.ID] = struct{}{}
+		if err := validateTags(t.Device.Tags); err != nil {
+			return nil, fmt.Errorf("模型=%s. %w", t.ID, err)
+		}
+		table := TableConfig[T]{ID: t.ID, Tags: t.Device.Tags, Commands: decodeCommands(t.Device.Commands)}
+		if err := decodeSettings(t.Device.Settings, &table.Settings); err != nil {
+			return nil, fmt.Errorf("模型=%s. 解析模型配置错误: %w", t.ID, err)
+		}
+		if err := validate(&table.Settings); err != nil {
+			return nil, fmt.Errorf("模型=%s. 模型配置校验错误: %w", t.ID, err)
+		}
+		cfg.Tables = append(cfg.Tables, table)
+		deviceIds := map[string]struct{}{}
+		for _, d := range t.Devices {
+			if d.ID == "" {
+				return nil, fmt.Errorf("模型=%s. 设备id为空", t.ID)
+			}
+			if _, ok := deviceIds[d.ID]; ok {
+				return nil, fmt.Errorf("模型=%s,设备=%s. 设备id重复", t.ID, d.ID)
+			}
+			deviceIds[d.ID] = struct{}{}
+			if err := validateTags(d.Device.Tags); err != nil {
+				return nil, fmt.Errorf("模型=%s,设备=%s. %w", t.ID, d.ID, err)
+			}
+			device := DeviceConfig[T, D]{
+				Table:         t.ID,
+				ID:            d.ID,
+				Name:          d.Name,
+				TableSettings: table.Settings,
+				Tags:          MergeTags(t.Device.Tags, d.Device.Tags),
+				Commands:      MergeCommands(table.Commands, decodeCommands(d.Device.Commands)),
+			}
+			if err := decodeSettings(mergeSettings(t.Device.Settings, d.Device.Settings), &device.Settings); err != nil {
+				return nil, fmt.Errorf("模型=%s,设备=%s. 解析设备配置错误: %w", t.ID, d.ID, err)
+			}
+			if err := validate(&device.Settings); err != nil {
+				return nil, fmt.Errorf("模型=%s,设备=%s. 设备配置校验错误: %w", t.ID, d.ID, err)
+			}
+			cfg.Devices = append(cfg.Devices, device)
+		}
+	}
+	return cfg, nil
+}
+
+// MergeTags 合并模型和设备数据点,设备数据点覆盖同标识的模型数据点
+func MergeTags(tableTags, deviceTags []entity.Tag) []entity.Tag {
+	tags := make([]entity.Tag, 0, len(tableTags)+len(deviceTags))
+	index := map[string]int{}
+	for _, tags1 := range [][]entity.Tag{tableTags, deviceTags} {
+		for _, tag := range tags1 {
+			if i, ok := index[tag.ID]; ok {
+				tags[i] = tag
+				continue
+			}
+			index[tag.ID] = len(tags)
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+// MergeCommands 合并模型和设备指令,设备指令覆盖同标识的模型指令
+func MergeCommands(tableCommands, deviceCommands []entity.CommandConfig) []entity.CommandConfig {
+	commands := make([]entity.CommandConfig, 0, len(tableCommands)+len(deviceCommands))
+	index := map[string]int{}
+	for _, commands1 := range [][]entity.CommandConfig{tableCommands, deviceCommands} {
+		for _, cmd := range commands1 {
+			if i, ok := index[cmd.ID]; ok {
+				commands[i] = cmd
+				continue
+			}
+			index[cmd.ID] = len(commands)
+			commands = append(commands, cmd)
+		}
+	}
+	return commands
+}
+
+func decodeCommands(raw []map[string]interface{}) []entity.CommandConfig {
+	commands := make([]entity.CommandConfig, 0, len(raw))
+	for _, c := range raw {
+		id, _ := c["id"].(string)
+		name, _ := c["name"].(string)
+		commands = append(commands, entity.CommandConfig{ID: id, Name: name, Config: c})
+	}
+	return commands
+}
+
+// mergeSettings 设备配置中存在的字段覆盖模型配置
+func mergeSettings(table, device map[string]interface{}) map[string]interface{} {
+	settings := make(map[string]interface{}, len(table)+len(device))
+	for k, v := range table {
+		settings[k] = v
+	}
+	for k, v := range device {
+		if v == nil {
+			continue
+		}
+		settings[k] = v
+	}
+	return settings
+}
+
+func decodeSettings(settings map[string]interface{}, dst interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+	return json.CopyByJson(dst, settings)
+}
+
+func validate(v interface{}) error {
+	if validator, ok := v.(Validator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+func validateTags(tags []entity.Tag) error {
+	ids := map[string]struct{}{}
+	for _, tag := range tags {
+		if tag.ID == "" {
+			return fmt.Errorf("数据点标识为空")
+		}
+		if _, ok := ids[tag.ID]; ok {
+			return fmt.Errorf("数据点=%s. 数据点标识重复", tag.ID)
+		}
+		ids[tag.ID] = struct{}{}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package driver
+
+import (
+	"fmt"
+	"testing"
+)
+
+type testSettings struct {
+	Server string `json:"server"`
+	Port   int    `json:"port"`
+}
+
+func (s *testSettings) Validate() error {
+	if s.Port < 0 {
+		return fmt.Errorf("端口错误")
+	}
+	return nil
+}
+
+func TestDecodeStartConfig(t *testing.T) {
+	bts := []byte(`{
+	"id": "i1",
+	"device": {"settings": {"server": "s0"}},
+	"tables": [{
+		"id": "t1",
+		"device": {
+			"settings": {"server": "s1", "port": 502},
+			"tags": [{"id": "p1", "name": "模型p1"}, {"id": "p2", "name": "模型p2"}],
+			"commands": [{"id": "c1", "name": "模型c1"}]
+		},
+		"devices": [
+			{"id": "d1", "device": {"settings": {"port": 503}, "tags": [{"id": "p2", "name": "设备p2"}, {"id": "p3", "name": "设备p3"}]}},
+			{"id": "d2"}
+		]
+	}]
+}`)
+	cfg, err := DecodeStartConfig[testSettings, testSettings, testSettings](bts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Settings.Server != "s0" {
+		t.Fatalf("实例配置错误: %+v", cfg.Settings)
+	}
+	if len(cfg.Devices) != 2 {
+		t.Fatalf("设备数量错误,应为=2,实际为=%d", len(cfg.Devices))
+	}
+	d1 := cfg.Devices[0]
+	if d1.Settings.Server != "s1" || d1.Settings.Port != 503 {
+		t.Fatalf("设备配置合并错误: %+v", d1.Settings)
+	}
+	if len(d1.Tags) != 3 || d1.Tags[1].Name != "设备p2" || d1.Tags[2].ID != "p3" {
+		t.Fatalf("设备数据点合并错误: %+v", d1.Tags)
+	}
+	if len(d1.Commands) != 1 || d1.Commands[0].Name != "模型c1" {
+		t.Fatalf("设备指令合并错误: %+v", d1.Commands)
+	}
+	if d2 := cfg.Devices[1]; d2.Settings.Port != 502 || len(d2.Tags) != 2 {
+		t.Fatalf("设备配置错误: %+v", d2)
+	}
+
+	if _, err := DecodeStartConfig[testSettings, testSettings, testSettings]([]byte(`{"tables": [{"id": "t1", "device": {"settings": {"port": -1}}}]}`)); err == nil {
+		t.Fatal("模型配置校验失败时应返回错误")
+	}
+	if _, err := DecodeStartConfig[testSettings, testSettings, testSettings]([]byte(`{"tables": [{"id": "t1", "devices": [{"id": "d1"}, {"id": "d1"}]}]}`)); err == nil {
+		t.Fatal("设备id重复时应返回错误")
+	}
+}
@@ -10,7 +10,8 @@ import (
 	"github.com/air-iot/json"
 	"github.com/air-iot/logger"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
 )
 
 const (
@@ -47,10 +48,14 @@ func (c *Client) Start(app App, flow Flow) *Client {
 
 func (c *Client) connFlow() error {
 	logger.Infof("连接流程引擎: 配置=%+v", Cfg.FlowEngine)
+	opts, err := grpcx.DialOptions(Cfg.FlowEngine.TLS, Cfg.FlowEngine.Token)
+	if err != nil {
+		return fmt.Errorf("grpc证书配置错误: %w", err)
+	}
 	conn, err := grpc.DialContext(
 		context.Background(),
 		fmt.Sprintf("%s:%d", Cfg.FlowEngine.Host, Cfg.FlowEngine.Port),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+		opts...)
 	if err != nil {
 		return fmt.Errorf("grpc.Dial error: %s", err)
 	}
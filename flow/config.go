@@ -5,6 +5,9 @@ import (
 	"encoding/hex"
 	"github.com/air-iot/logger"
 	"google.golang.org/grpc/metadata"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
 )
 
 // Cfg 全局配置(需要先执行MustLoad，否则拿不到配置)
@@ -26,8 +29,10 @@ type Config struct {
 }
 
 type Grpc struct {
-	Host string `json:"host" yaml:"host"`
-	Port int    `json:"port" yaml:"port"`
+	Host  string      `json:"host" yaml:"host"`
+	Port  int         `json:"port" yaml:"port"`
+	TLS   tlsx.Config `json:"tls" yaml:"tls"`
+	Token grpcx.Token `json:"token" yaml:"token"` // 认证token,每次请求以Bearer token携带
 }
 
 type TaskMode string
@@ -8,10 +8,11 @@ import (
 
 	"github.com/air-iot/json"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
 
 	pb "github.com/air-iot/api-client-go/v4/engine"
 	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/grpcx"
 )
 
 const (
@@ -48,10 +49,14 @@ func (c *Client) Start(app App, extension Extension) *Client {
 
 func (c *Client) connFlow() error {
 	logger.Infof("连接flow: 配置=%+v", Cfg.FlowEngine)
+	opts, err := grpcx.DialOptions(Cfg.FlowEngine.TLS, Cfg.FlowEngine.Token)
+	if err != nil {
+		return fmt.Errorf("grpc证书配置错误: %w", err)
+	}
 	conn, err := grpc.DialContext(
 		context.Background(),
 		fmt.Sprintf("%s:%d", Cfg.FlowEngine.Host, Cfg.FlowEngine.Port),
-		grpc.WithTransportCredentials(insecure.NewCredentials()))
+		opts...)
 	if err != nil {
 		return fmt.Errorf("grpc.Dial error: %s", err)
 	}
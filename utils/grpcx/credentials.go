@@ -0,0 +1,58 @@
+package grpcx
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/air-iot/logger"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
+)
+
+// Token 认证token,打印配置时不输出明文
+type Token string
+
+func (t Token) String() string {
+	if t == "" {
+		return ""
+	}
+	return "******"
+}
+
+// tokenCredentials 每次请求携带Bearer token
+type tokenCredentials struct {
+	token  Token
+	secure bool
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t.token)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return t.secure
+}
+
+// DialOptions 根据TLS和token配置生成连接参数,未启用TLS时使用明文连接,此时配置的token同样以明文发送
+func DialOptions(tlsCfg tlsx.Config, token Token) ([]grpc.DialOption, error) {
+	cfg, err := tlsCfg.Load()
+	if err != nil {
+		return nil, err
+	}
+	opts := make([]grpc.DialOption, 0, 2)
+	if cfg != nil {
+		opts = append(opts, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	} else {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	if token != "" {
+		if cfg == nil {
+			logger.Warnf("gRPC连接未启用TLS,认证token将以明文发送,建议启用TLS")
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(tokenCredentials{token: token, secure: cfg != nil}))
+	}
+	return opts, nil
+}
@@ -0,0 +1,32 @@
+package grpcx
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/air-iot/sdk-go/v4/utils/tlsx"
+)
+
+func TestDialOptions(t *testing.T) {
+	opts, err := DialOptions(tlsx.Config{}, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(opts) != 2 {
+		t.Fatalf("连接参数数量应为=2,实际为=%d", len(opts))
+	}
+	if _, err := DialOptions(tlsx.Config{Enable: true, CAFile: "not-exist.pem"}, ""); err == nil {
+		t.Fatal("CA证书不存在时应返回错误")
+	}
+	md, err := tokenCredentials{token: "abc"}.GetRequestMetadata(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if md["authorization"] != "Bearer abc" {
+		t.Fatalf("token不匹配: %v", md)
+	}
+	if s := fmt.Sprintf("%+v", struct{ Token Token }{Token: "abc"}); s != "{Token:******}" {
+		t.Fatalf("打印配置不应输出token明文: %s", s)
+	}
+}
@@ -0,0 +1,49 @@
+package tlsx
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// Config TLS配置
+type Config struct {
+	Enable             bool   `json:"enable" yaml:"enable"`
+	CAFile             string `json:"caFile" yaml:"caFile"`                         // CA证书,为空时使用系统证书
+	CertFile           string `json:"certFile" yaml:"certFile"`                     // 客户端证书,双向认证时配置
+	KeyFile            string `json:"keyFile" yaml:"keyFile"`                       // 客户端私钥,双向认证时配置
+	ServerName         string `json:"serverName" yaml:"serverName"`                 // 校验的服务端名称,为空时使用连接地址
+	InsecureSkipVerify bool   `json:"insecureSkipVerify" yaml:"insecureSkipVerify"` // 跳过服务端证书校验
+}
+
+// Load 读取证书生成tls配置,未启用时返回nil
+func (c Config) Load() (*tls.Config, error) {
+	if !c.Enable {
+		return nil, nil
+	}
+	tlsCfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+	if c.CAFile != "" {
+		ca, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取CA证书错误: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("解析CA证书错误: %s", c.CAFile)
+		}
+		tlsCfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("读取客户端证书错误: %w", err)
+		}
+		tlsCfg.Certificates = []tls.Certificate{cert}
+	}
+	return tlsCfg, nil
+}